@@ -0,0 +1,33 @@
+// Command rotate-keys rewraps every stored data key with the active master
+// key. To rotate, add the new master key to ENCRYPTION_KEYS (or the key file),
+// make it active, run this command, then remove the old key once it reports
+// success. Encrypted blobs themselves are not rewritten.
+package main
+
+import (
+	"log"
+
+	"filevault-backend/internal/config"
+	"filevault-backend/internal/database"
+	"filevault-backend/internal/services"
+)
+
+func main() {
+	cfg := config.Load()
+	if err := database.Connect(cfg.DatabaseURL); err != nil {
+		log.Fatal("Failed to connect to database:", err)
+	}
+	keyRing, err := services.NewKeyRing(cfg)
+	if err != nil {
+		log.Fatal("Failed to load encryption keys:", err)
+	}
+	if keyRing == nil {
+		log.Fatal("No encryption keys configured")
+	}
+
+	rewrapped, err := keyRing.RewrapDataKeys()
+	if err != nil {
+		log.Fatalf("Key rotation stopped after rewrapping %d data key(s): %v", rewrapped, err)
+	}
+	log.Printf("Rewrapped %d data key(s) with master key %q", rewrapped, keyRing.ActiveKeyID())
+}
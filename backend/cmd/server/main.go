@@ -3,37 +3,75 @@ package main
 
 import (
 	"log"
-	"os"
+	"time"
 
 	"filevault-backend/internal/config"
 	"filevault-backend/internal/database"
 	"filevault-backend/internal/handlers"
 	"filevault-backend/internal/middleware"
 	"filevault-backend/internal/services"
+
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
 	cfg := config.Load()
+	if err := services.ValidateQuotaAccounting(cfg.QuotaAccounting); err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+	if err := services.ValidateScanFailurePolicy(cfg.ScanFailurePolicy); err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
 	if err := database.Connect(cfg.DatabaseURL); err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 	if err := database.Migrate(); err != nil {
 		log.Fatal("Failed to run migrations:", err)
 	}
-	if err := os.MkdirAll(cfg.UploadPath, 0755); err != nil {
-		log.Fatal("Failed to create upload directory:", err)
+	storageService, err := services.NewStorageService(cfg)
+	if err != nil {
+		log.Fatal("Failed to initialize storage:", err)
+	}
+	scanner, err := services.NewScanner(cfg)
+	if err != nil {
+		log.Fatal("Failed to initialize malware scanner:", err)
+	}
+	keyRing, err := services.NewKeyRing(cfg)
+	if err != nil {
+		log.Fatal("Failed to load encryption keys:", err)
+	}
+	if keyRing == nil {
+		log.Println("Warning: no encryption keys configured; new files are stored unencrypted")
 	}
 
-	authService := services.NewAuthService(cfg.JWTSecret)
-	storageService := services.NewStorageService(cfg.UploadPath)
-	fileService := services.NewFileService(storageService, cfg.MaxFileSize)
+	sessionService := services.NewSessionService()
+	authService := services.NewAuthService(cfg.JWTSecret, cfg.StorageQuota, time.Duration(cfg.AccessTokenMinutes)*time.Minute, time.Duration(cfg.RefreshTokenDays)*24*time.Hour, sessionService)
+	jobService := services.NewJobService(cfg.JobWorkers, cfg.JobMaxAttempts)
+	fileService := services.NewFileService(storageService, jobService, scanner, cfg.ScanFailurePolicy, keyRing, cfg.MaxFileSize, cfg.StorageQuota, cfg.QuotaAccounting, time.Duration(cfg.TrashRetentionDays)*24*time.Hour)
 	auditService := services.NewAuditService()
-	authHandler := handlers.NewAuthHandler(authService)
-	fileHandler := handlers.NewFileHandler(fileService, storageService, auditService)
-	adminHandler := handlers.NewAdminHandler(fileService, storageService, auditService)
+	uploadService := services.NewUploadService(fileService, storageService, time.Duration(cfg.UploadExpiryHours)*time.Hour)
+	userService := services.NewUserService(fileService, sessionService)
+	twoFactorService := services.NewTwoFactorService(cfg.TOTPIssuer, authService, sessionService)
+	shareService := services.NewShareService(authService)
+	accessService := services.NewAccessService()
+	folderService := services.NewFolderService(fileService)
+	tagService := services.NewTagService()
+	authHandler := handlers.NewAuthHandler(authService, twoFactorService, auditService)
+	fileHandler := handlers.NewFileHandler(fileService, storageService, auditService, shareService, accessService, folderService, tagService)
+	folderHandler := handlers.NewFolderHandler(folderService, auditService)
+	trashHandler := handlers.NewTrashHandler(fileService, auditService)
+	tagHandler := handlers.NewTagHandler(tagService, auditService)
+	uploadHandler := handlers.NewUploadHandler(uploadService, folderService, auditService)
+	adminHandler := handlers.NewAdminHandler(fileService, storageService, auditService, userService, sessionService, twoFactorService)
+	jobHandler := handlers.NewJobHandler(jobService, auditService)
+	sessionHandler := handlers.NewSessionHandler(sessionService, auditService)
 	rateLimiter := middleware.NewRateLimiter(cfg.RateLimit, 10)
 
+	fileService.StartTrashPurge(time.Hour)
+	uploadService.StartCleanup(time.Hour)
+	authService.StartTokenCleanup(time.Hour)
+	jobService.Start()
+
 	router := gin.Default()
 
 	// Apply CORS and Rate Limiter to all routes
@@ -48,12 +86,19 @@ func main() {
 		{
 			auth.POST("/register", authHandler.Register)
 			auth.POST("/login", authHandler.Login)
+			auth.POST("/refresh", authHandler.Refresh)
+			auth.POST("/logout", authHandler.Logout)
+			auth.POST("/2fa", authHandler.VerifyTwoFactor) // Second login step for accounts with two-factor authentication
 		}
 
-		// Public group for unauthenticated downloads of shared files
+		// tus discovery is unauthenticated so clients can probe server capabilities
+		api.OPTIONS("/files/uploads", uploadHandler.Options)
+
+		// Public group for unauthenticated downloads through share links
 		public := api.Group("/public")
 		{
-			public.GET("/files/:id/download", fileHandler.PublicDownload)
+			public.GET("/shares/:token/download", fileHandler.PublicDownload)
+			public.HEAD("/shares/:token/download", fileHandler.PublicDownload)
 		}
 
 		// Group for all routes that require standard user authentication
@@ -61,26 +106,106 @@ func main() {
 		protected.Use(middleware.AuthMiddleware(authService))
 		{
 			protected.GET("/profile", authHandler.GetProfile)
+			protected.PUT("/profile/public-key", authHandler.UpdatePublicKey)
+			protected.POST("/profile/2fa", authHandler.EnrollTwoFactor)
+			protected.POST("/profile/2fa/confirm", authHandler.ConfirmTwoFactor)
+			protected.DELETE("/profile/2fa", authHandler.DisableTwoFactor)
+			protected.POST("/profile/2fa/recovery-codes", authHandler.RegenerateRecoveryCodes)
+			protected.GET("/users/public-key", authHandler.GetPublicKey) // ?user=<username or email>
+
+			sessions := protected.Group("/sessions")
+			{
+				sessions.GET("", sessionHandler.ListSessions)
+				sessions.DELETE("", sessionHandler.TerminateOtherSessions) // Log out everywhere else
+				sessions.DELETE("/:id", sessionHandler.TerminateSession)
+			}
 			protected.GET("/storage/stats", fileHandler.GetStorageStats)
 
 			files := protected.Group("/files")
 			{
 				files.POST("/upload", fileHandler.UploadFile)
 				files.GET("", fileHandler.GetUserFiles)
+				files.GET("/shared", fileHandler.GetSharedWithMe)    // Files other users shared with me
 				files.GET("/:id/download", fileHandler.DownloadFile) // Authenticated download
+				files.HEAD("/:id/download", fileHandler.DownloadFile)
+				files.GET("/:id/thumbnail", fileHandler.GetThumbnail) // ?size=small|medium|large
+				files.GET("/:id", fileHandler.GetFile)
+				files.PATCH("/:id", fileHandler.UpdateFile)               // Rename or move
+				files.PUT("/:id/content", fileHandler.ReplaceFileContent) // Upload a new version of the data
+				files.POST("/:id/tags", fileHandler.TagFile)
+				files.DELETE("/:id/tags/:tag", fileHandler.UntagFile)
+				files.GET("/:id/versions", fileHandler.ListVersions)
+				files.GET("/:id/versions/:version/download", fileHandler.DownloadVersion)
+				files.HEAD("/:id/versions/:version/download", fileHandler.DownloadVersion)
+				files.POST("/:id/versions/:version/restore", fileHandler.RestoreVersion)
 				files.DELETE("/:id", fileHandler.DeleteFile)
-				files.PUT("/:id/share", fileHandler.ShareFile) // Toggle sharing status
+				files.POST("/:id/shares", fileHandler.ShareFile) // Create a share link
+				files.GET("/:id/shares", fileHandler.ListShares)
+				files.DELETE("/:id/shares/:shareId", fileHandler.RevokeShare)
+				files.POST("/:id/collaborators", fileHandler.ShareWithUser) // Share with a registered user
+				files.GET("/:id/collaborators", fileHandler.ListUserShares)
+				files.DELETE("/:id/collaborators/:userId", fileHandler.RevokeUserShare)
+				files.GET("/:id/key", fileHandler.GetFileKey) // Wrapped key of a client-encrypted file
+				files.PUT("/:id/key", fileHandler.UpdateFileKey)
+
+				// Resumable uploads (tus 1.0)
+				uploads := files.Group("/uploads")
+				uploads.Use(uploadHandler.TusResumable())
+				{
+					uploads.POST("", uploadHandler.Create)
+					uploads.HEAD("/:uploadId", uploadHandler.Head)
+					uploads.PATCH("/:uploadId", uploadHandler.Patch)
+					uploads.DELETE("/:uploadId", uploadHandler.Terminate)
+				}
+			}
+
+			tags := protected.Group("/tags")
+			{
+				tags.GET("", tagHandler.ListTags)
+				tags.PATCH("/:id", tagHandler.RenameTag)
+				tags.DELETE("/:id", tagHandler.DeleteTag)
+			}
+
+			trash := protected.Group("/trash")
+			{
+				trash.GET("", trashHandler.ListTrash)
+				trash.DELETE("", trashHandler.EmptyTrash)
+				trash.POST("/:id/restore", trashHandler.RestoreFile)
+				trash.DELETE("/:id", trashHandler.PurgeFile) // Delete permanently
+			}
+
+			folders := protected.Group("/folders")
+			{
+				folders.POST("", folderHandler.CreateFolder)
+				folders.GET("", folderHandler.ListFolders)
+				folders.GET("/lookup", folderHandler.LookupPath) // Resolve a path to a folder or file
+				folders.GET("/:id", folderHandler.GetFolder)
+				folders.PATCH("/:id", folderHandler.UpdateFolder) // Rename or move
+				folders.DELETE("/:id", folderHandler.DeleteFolder)
 			}
 		}
 
 		// Group for admin-only routes
 		admin := api.Group("/admin")
-		admin.Use(middleware.AuthMiddleware(authService), middleware.AdminMiddleware())
+		admin.Use(middleware.AuthMiddleware(authService), middleware.AdminMiddleware(twoFactorService))
 		{
 			admin.GET("/files", adminHandler.GetAllFiles)
 			admin.GET("/stats", adminHandler.GetSystemStats)
 			admin.GET("/users", adminHandler.GetUsers)
+			admin.PUT("/users/:id/quota", adminHandler.UpdateUserQuota)
+			admin.PUT("/users/:id/role", adminHandler.UpdateUserRole)
+			admin.POST("/users/:id/suspend", adminHandler.SuspendUser)
+			admin.POST("/users/:id/reactivate", adminHandler.ReactivateUser)
+			admin.DELETE("/users/:id", adminHandler.DeleteUser)
+			admin.GET("/users/:id/sessions", adminHandler.GetUserSessions)
+			admin.DELETE("/users/:id/sessions", adminHandler.TerminateUserSessions) // Log the user out everywhere
 			admin.GET("/audit-logs", adminHandler.GetAuditLogs)
+			admin.GET("/settings/2fa", adminHandler.GetTwoFactorPolicy)
+			admin.PUT("/settings/2fa", adminHandler.UpdateTwoFactorPolicy) // Require two-factor authentication for admins
+			admin.GET("/jobs", jobHandler.ListJobs)
+			admin.GET("/jobs/stats", jobHandler.GetJobStats)
+			admin.GET("/jobs/:id", jobHandler.GetJob)
+			admin.POST("/jobs/:id/retry", jobHandler.RetryJob) // Requeue a dead job
 		}
 	}
 
@@ -88,4 +213,4 @@ func main() {
 	if err := router.Run(":" + cfg.Port); err != nil {
 		log.Fatal("Failed to start server:", err)
 	}
-}
\ No newline at end of file
+}
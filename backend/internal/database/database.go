@@ -4,10 +4,11 @@ package database
 import (
 	"log"
 
+	"filevault-backend/internal/models"
+
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
-	"filevault-backend/internal/models"
 )
 
 var DB *gorm.DB
@@ -27,11 +28,61 @@ func Connect(databaseURL string) error {
 
 func Migrate() error {
 	log.Println("Running database migrations...")
-	return DB.AutoMigrate(
+	err := DB.AutoMigrate(
 		&models.User{},
 		&models.FileContent{}, // Added FileContent for deduplication
+		&models.Folder{},
+		&models.Tag{},
 		&models.File{},
+		&models.FileVersion{},
 		&models.FileShare{},
+		&models.FileKey{},
 		&models.AuditLog{},
+		&models.UploadSession{},
+		&models.Job{},
+		&models.RefreshToken{},
+		&models.Session{},
+		&models.RecoveryCode{},
+		&models.TwoFactorChallenge{},
+		&models.Setting{},
 	)
-}
\ No newline at end of file
+	if err != nil {
+		return err
+	}
+
+	// Files uploaded before versioning existed get their content as version 1.
+	err = DB.Exec(`INSERT INTO file_versions (file_id, version, file_content_id, uploaded_by, created_at)
+		SELECT id, 1, file_content_id, user_id, created_at FROM files
+		WHERE deleted_at IS NULL AND NOT EXISTS (SELECT 1 FROM file_versions WHERE file_versions.file_id = files.id)`).Error
+	if err != nil {
+		return err
+	}
+
+	// A user without a quota of their own used to be stored with 0, and new
+	// users got a 10MB column default; both now leave the quota unset.
+	for _, stmt := range []string{
+		`ALTER TABLE users ALTER COLUMN storage_quota DROP DEFAULT`,
+		`UPDATE users SET storage_quota = NULL WHERE storage_quota = 0`,
+	} {
+		if err := DB.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+
+	// Full-text search vectors are generated by Postgres and are not part of
+	// the models. File names are split on punctuation so "q3-report.pdf"
+	// matches "report".
+	for _, stmt := range []string{
+		`ALTER TABLE files ADD COLUMN IF NOT EXISTS name_vector tsvector
+			GENERATED ALWAYS AS (to_tsvector('simple', regexp_replace(original_filename, '[^[:alnum:]]+', ' ', 'g'))) STORED`,
+		`CREATE INDEX IF NOT EXISTS idx_files_name_vector ON files USING GIN (name_vector)`,
+		`ALTER TABLE file_contents ADD COLUMN IF NOT EXISTS text_vector tsvector
+			GENERATED ALWAYS AS (to_tsvector('english', coalesce(extracted_text, ''))) STORED`,
+		`CREATE INDEX IF NOT EXISTS idx_file_contents_text_vector ON file_contents USING GIN (text_vector)`,
+	} {
+		if err := DB.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authorizeFile loads the file named by the :id parameter and checks that the
+// current user holds perm on it, writing the error response itself when not.
+// It also returns every permission the user holds on the file.
+func (h *FileHandler) authorizeFile(c *gin.Context, perm models.Permission) (*models.File, models.Permission, bool) {
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid file ID")
+		return nil, 0, false
+	}
+
+	file, err := h.fileService.GetByID(uint(fileID))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "File not found")
+		return nil, 0, false
+	}
+
+	userID, _ := c.Get("userID")
+	isAdmin, _ := c.Get("isAdmin")
+	perms := h.accessService.Permissions(file, userID.(uint), isAdmin.(bool))
+	if perms == 0 {
+		// Don't reveal that the file exists to users who cannot see it.
+		utils.ErrorResponse(c, http.StatusNotFound, "File not found")
+		return nil, 0, false
+	}
+	if !perms.Has(perm) {
+		utils.ErrorResponse(c, http.StatusForbidden, fmt.Sprintf("Access denied: you do not have %s permission on this file", perm))
+		return nil, 0, false
+	}
+	return file, perms, true
+}
@@ -2,25 +2,36 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
-	"github.com/gin-gonic/gin"
+	"strconv"
+
 	"filevault-backend/internal/database"
 	"filevault-backend/internal/models"
 	"filevault-backend/internal/services"
 	"filevault-backend/internal/utils"
+
+	"github.com/gin-gonic/gin"
 )
 
 type AdminHandler struct {
-	fileService    *services.FileService
-	storageService *services.StorageService
-	auditService   *services.AuditService
+	fileService      *services.FileService
+	storageService   services.StorageService
+	auditService     *services.AuditService
+	userService      *services.UserService
+	sessionService   *services.SessionService
+	twoFactorService *services.TwoFactorService
 }
 
-func NewAdminHandler(fileService *services.FileService, storageService *services.StorageService, auditService *services.AuditService) *AdminHandler {
+func NewAdminHandler(fileService *services.FileService, storageService services.StorageService, auditService *services.AuditService, userService *services.UserService, sessionService *services.SessionService, twoFactorService *services.TwoFactorService) *AdminHandler {
 	return &AdminHandler{
-		fileService:    fileService,
-		storageService: storageService,
-		auditService:   auditService,
+		fileService:      fileService,
+		storageService:   storageService,
+		auditService:     auditService,
+		userService:      userService,
+		sessionService:   sessionService,
+		twoFactorService: twoFactorService,
 	}
 }
 
@@ -81,7 +92,7 @@ func (h *AdminHandler) GetSystemStats(c *gin.Context) {
 	database.DB.Model(&models.User{}).Count(&stats.TotalUsers)
 	database.DB.Model(&models.File{}).Count(&stats.TotalFiles)
 	database.DB.Model(&models.FileContent{}).Select("COALESCE(SUM(file_size), 0)").Scan(&stats.TotalStorageUsed)
-	database.DB.Model(&models.File{}).Joins("JOIN file_contents ON file_contents.id = files.file_content_id").
+	database.DB.Model(&models.FileVersion{}).Joins("JOIN file_contents ON file_contents.id = file_versions.file_content_id").
 		Select("COALESCE(SUM(file_contents.file_size), 0)").Scan(&stats.OriginalTotalSize)
 
 	stats.DeduplicationSaved = stats.OriginalTotalSize - stats.TotalStorageUsed
@@ -108,4 +119,187 @@ func (h *AdminHandler) GetAuditLogs(c *gin.Context) {
 		return
 	}
 	utils.SuccessResponse(c, "Audit logs retrieved successfully", logs)
-}
\ No newline at end of file
+}
+
+func (h *AdminHandler) UpdateUserQuota(c *gin.Context) {
+	userID, ok := parseUserID(c)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	user, err := h.userService.UpdateQuota(userID, req.StorageQuota)
+	if err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	quota := "the default"
+	if req.StorageQuota != nil {
+		quota = fmt.Sprintf("%d bytes", *req.StorageQuota)
+	}
+	h.auditService.Log(c, "UPDATE_QUOTA", "USER", &user.ID, fmt.Sprintf("Admin set storage quota of '%s' to %s", user.Username, quota))
+	utils.SuccessResponse(c, "User quota updated successfully", user)
+}
+
+func (h *AdminHandler) UpdateUserRole(c *gin.Context) {
+	userID, ok := parseUserID(c)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	if !*req.IsAdmin && isCurrentUser(c, userID) {
+		utils.ErrorResponse(c, http.StatusBadRequest, "You cannot remove your own admin role")
+		return
+	}
+
+	user, err := h.userService.SetAdmin(userID, *req.IsAdmin)
+	if err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	action := "DEMOTE"
+	if *req.IsAdmin {
+		action = "PROMOTE"
+	}
+	h.auditService.Log(c, action, "USER", &user.ID, fmt.Sprintf("Admin set admin role of '%s' to %v", user.Username, *req.IsAdmin))
+	utils.SuccessResponse(c, "User role updated successfully", user)
+}
+
+func (h *AdminHandler) SuspendUser(c *gin.Context) {
+	h.setSuspended(c, true)
+}
+
+func (h *AdminHandler) ReactivateUser(c *gin.Context) {
+	h.setSuspended(c, false)
+}
+
+func (h *AdminHandler) setSuspended(c *gin.Context, suspended bool) {
+	userID, ok := parseUserID(c)
+	if !ok {
+		return
+	}
+
+	if suspended && isCurrentUser(c, userID) {
+		utils.ErrorResponse(c, http.StatusBadRequest, "You cannot suspend your own account")
+		return
+	}
+
+	user, err := h.userService.SetSuspended(userID, suspended)
+	if err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	if suspended {
+		h.auditService.Log(c, "SUSPEND", "USER", &user.ID, fmt.Sprintf("Admin suspended user '%s'", user.Username))
+		utils.SuccessResponse(c, "User suspended successfully", user)
+		return
+	}
+	h.auditService.Log(c, "REACTIVATE", "USER", &user.ID, fmt.Sprintf("Admin reactivated user '%s'", user.Username))
+	utils.SuccessResponse(c, "User reactivated successfully", user)
+}
+
+func (h *AdminHandler) DeleteUser(c *gin.Context) {
+	userID, ok := parseUserID(c)
+	if !ok {
+		return
+	}
+
+	if isCurrentUser(c, userID) {
+		utils.ErrorResponse(c, http.StatusBadRequest, "You cannot delete your own account")
+		return
+	}
+
+	user, err := h.userService.GetByID(userID)
+	if err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	deletedFiles, err := h.userService.Delete(userID)
+	if err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	h.auditService.Log(c, "DELETE", "USER", &user.ID, fmt.Sprintf("Admin deleted user '%s' and %d file(s)", user.Username, deletedFiles))
+	utils.SuccessResponse(c, "User deleted successfully", gin.H{"deleted_files": deletedFiles})
+}
+
+// GetUserSessions lists where a user is logged in.
+func (h *AdminHandler) GetUserSessions(c *gin.Context) {
+	userID, ok := parseUserID(c)
+	if !ok {
+		return
+	}
+
+	if _, err := h.userService.GetByID(userID); err != nil {
+		respondUserError(c, err)
+		return
+	}
+	sessions, err := h.sessionService.List(userID)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve sessions: "+err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Sessions retrieved successfully", gin.H{"sessions": sessions})
+}
+
+// TerminateUserSessions logs a user out on every device. Unlike suspending
+// the account, the user can log in again straight away.
+func (h *AdminHandler) TerminateUserSessions(c *gin.Context) {
+	userID, ok := parseUserID(c)
+	if !ok {
+		return
+	}
+
+	user, err := h.userService.GetByID(userID)
+	if err != nil {
+		respondUserError(c, err)
+		return
+	}
+	terminated, err := h.sessionService.TerminateAll(user.ID, "")
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to terminate sessions: "+err.Error())
+		return
+	}
+
+	h.auditService.Log(c, "TERMINATE_SESSIONS", "USER", &user.ID, fmt.Sprintf("Admin terminated %d session(s) of user '%s'", terminated, user.Username))
+	utils.SuccessResponse(c, "Sessions terminated successfully", gin.H{"terminated": terminated})
+}
+
+func parseUserID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid user ID")
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func isCurrentUser(c *gin.Context, userID uint) bool {
+	currentID, _ := c.Get("userID")
+	return currentID == userID
+}
+
+func respondUserError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrUserNotFound) {
+		utils.ErrorResponse(c, http.StatusNotFound, "User not found")
+		return
+	}
+	utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to update user: "+err.Error())
+}
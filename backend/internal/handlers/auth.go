@@ -1,21 +1,27 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
-	"github.com/gin-gonic/gin"
 	"filevault-backend/internal/models"
 	"filevault-backend/internal/services"
 	"filevault-backend/internal/utils"
+
+	"github.com/gin-gonic/gin"
 )
 
 type AuthHandler struct {
-	authService *services.AuthService
+	authService      *services.AuthService
+	twoFactorService *services.TwoFactorService
+	auditService     *services.AuditService
 }
 
-func NewAuthHandler(authService *services.AuthService) *AuthHandler {
+func NewAuthHandler(authService *services.AuthService, twoFactorService *services.TwoFactorService, auditService *services.AuditService) *AuthHandler {
 	return &AuthHandler{
-		authService: authService,
+		authService:      authService,
+		twoFactorService: twoFactorService,
+		auditService:     auditService,
 	}
 }
 
@@ -32,18 +38,13 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	token, err := h.authService.GenerateToken(user)
+	tokens, err := h.authService.IssueTokens(user, c.Request.UserAgent(), c.ClientIP())
 	if err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to generate token")
 		return
 	}
 
-	response := models.LoginResponse{
-		Token: token,
-		User:  *user,
-	}
-
-	utils.SuccessResponse(c, "User registered successfully", response)
+	utils.SuccessResponse(c, "User registered successfully", loginResponse(tokens, user))
 }
 
 func (h *AuthHandler) Login(c *gin.Context) {
@@ -55,22 +56,88 @@ func (h *AuthHandler) Login(c *gin.Context) {
 
 	user, err := h.authService.Login(&req)
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusUnauthorized, err.Error())
+		status := http.StatusUnauthorized
+		if errors.Is(err, services.ErrAccountSuspended) {
+			status = http.StatusForbidden
+		}
+		utils.ErrorResponse(c, status, err.Error())
+		return
+	}
+
+	// With two-factor authentication the password only earns a challenge,
+	// which VerifyTwoFactor exchanges for tokens.
+	if user.TOTPEnabled {
+		challenge, expiresAt, err := h.twoFactorService.StartChallenge(user)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to start two-factor challenge")
+			return
+		}
+		utils.SuccessResponse(c, "Two-factor authentication required", models.TwoFactorChallengeResponse{
+			TwoFactorRequired: true,
+			Challenge:         challenge,
+			ExpiresAt:         expiresAt,
+		})
 		return
 	}
 
-	token, err := h.authService.GenerateToken(user)
+	tokens, err := h.authService.IssueTokens(user, c.Request.UserAgent(), c.ClientIP())
 	if err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to generate token")
 		return
 	}
 
-	response := models.LoginResponse{
-		Token: token,
-		User:  *user,
+	utils.SuccessResponse(c, "Login successful", loginResponse(tokens, user))
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh
+// token; the one presented cannot be used again.
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req models.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	tokens, user, err := h.authService.Refresh(req.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidRefreshToken), errors.Is(err, services.ErrRefreshTokenReused):
+			utils.ErrorResponse(c, http.StatusUnauthorized, err.Error())
+		case errors.Is(err, services.ErrAccountSuspended):
+			utils.ErrorResponse(c, http.StatusForbidden, err.Error())
+		default:
+			utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to refresh token")
+		}
+		return
 	}
 
-	utils.SuccessResponse(c, "Login successful", response)
+	utils.SuccessResponse(c, "Token refreshed successfully", loginResponse(tokens, user))
+}
+
+// Logout revokes a refresh token together with every token issued from the
+// same login, including access tokens that have not expired yet.
+func (h *AuthHandler) Logout(c *gin.Context) {
+	var req models.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	if err := h.authService.Logout(req.RefreshToken); err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to log out")
+		return
+	}
+
+	utils.SuccessResponse(c, "Logged out successfully", nil)
+}
+
+func loginResponse(tokens *services.TokenPair, user *models.User) models.LoginResponse {
+	return models.LoginResponse{
+		Token:        tokens.AccessToken,
+		ExpiresAt:    tokens.ExpiresAt,
+		RefreshToken: tokens.RefreshToken,
+		User:         *user,
+	}
 }
 
 func (h *AuthHandler) GetProfile(c *gin.Context) {
@@ -79,9 +146,10 @@ func (h *AuthHandler) GetProfile(c *gin.Context) {
 	isAdmin, _ := c.Get("isAdmin")
 
 	user := map[string]interface{}{
-		"id":       userID,
-		"username": username,
-		"is_admin": isAdmin,
+		"id":           userID,
+		"username":     username,
+		"is_admin":     isAdmin,
+		"totp_enabled": c.GetBool("twoFactor"),
 	}
 
 	utils.SuccessResponse(c, "Profile retrieved successfully", user)
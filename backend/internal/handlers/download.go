@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/services"
+	"filevault-backend/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamContent writes a stored blob to the response. Range, If-Range,
+// If-None-Match and If-Modified-Since are handled by http.ServeContent, which
+// only reads the byte ranges it needs from storage. Content found to contain
+// malware, or still waiting for its scan, is refused, and so is content that
+// could not be scanned unless the scan failure policy allows it.
+//
+// It returns true when the response starts a fresh download of the file
+// (a full 200 or a range beginning at byte 0), which is what callers count
+// as a download; seeks, HEAD requests and 304s return false.
+func (h *FileHandler) streamContent(c *gin.Context, content *models.FileContent, filename string, modTime time.Time) bool {
+	switch content.ScanStatus {
+	case models.ScanInfected:
+		utils.ErrorResponse(c, http.StatusForbidden, "This file contains malware and cannot be downloaded")
+		return false
+	case models.ScanPending:
+		c.Header("Retry-After", "30")
+		utils.ErrorResponse(c, http.StatusConflict, "This file is still being scanned for malware; try again shortly")
+		return false
+	case models.ScanFailed:
+		if h.fileService.ScanFailureBlocks() {
+			utils.ErrorResponse(c, http.StatusForbidden, "This file could not be scanned for malware and cannot be downloaded")
+			return false
+		}
+	}
+
+	reader, err := h.fileService.OpenContent(content)
+	if errors.Is(err, services.ErrObjectNotFound) {
+		utils.ErrorResponse(c, http.StatusNotFound, "File data not found in storage")
+		return false
+	}
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to read file data: "+err.Error())
+		return false
+	}
+	defer reader.Close()
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", content.MimeType)
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	// The content is addressed by its hash, so the hash is a strong validator.
+	header.Set("ETag", `"`+content.SHA256Hash+`"`)
+	header.Set("Cache-Control", "private, no-cache")
+
+	http.ServeContent(c.Writer, c.Request, filename, modTime, reader)
+
+	return isFreshDownload(c) && servedContent(c)
+}
+
+// servedContent reports whether the response sent file data, all of it or
+// some ranges.
+func servedContent(c *gin.Context) bool {
+	status := c.Writer.Status()
+	return c.Request.Method != http.MethodHead && (status == http.StatusOK || status == http.StatusPartialContent)
+}
+
+// isFreshDownload reports whether a request starts reading a file from the
+// beginning, as opposed to a HEAD probe or a client seeking within a file.
+func isFreshDownload(c *gin.Context) bool {
+	if c.Request.Method == http.MethodHead {
+		return false
+	}
+	rangeHeader := c.GetHeader("Range")
+	return rangeHeader == "" || strings.HasPrefix(rangeHeader, "bytes=0-")
+}
@@ -2,30 +2,40 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"strconv"
 
-	"filevault-backend/internal/database"
 	"filevault-backend/internal/models"
 	"filevault-backend/internal/services"
 	"filevault-backend/internal/utils"
 
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm"
 )
 
 type FileHandler struct {
 	fileService    *services.FileService
-	storageService *services.StorageService
+	storageService services.StorageService
 	auditService   *services.AuditService
+	shareService   *services.ShareService
+	accessService  *services.AccessService
+	folderService  *services.FolderService
+	tagService     *services.TagService
 }
 
-func NewFileHandler(fileService *services.FileService, storageService *services.StorageService, auditService *services.AuditService) *FileHandler {
+func NewFileHandler(fileService *services.FileService, storageService services.StorageService, auditService *services.AuditService, shareService *services.ShareService, accessService *services.AccessService, folderService *services.FolderService, tagService *services.TagService) *FileHandler {
 	return &FileHandler{
 		fileService:    fileService,
 		storageService: storageService,
 		auditService:   auditService,
+		shareService:   shareService,
+		accessService:  accessService,
+		folderService:  folderService,
+		tagService:     tagService,
 	}
 }
 
@@ -36,101 +46,167 @@ func (h *FileHandler) UploadFile(c *gin.Context) {
 		return
 	}
 
-	form, err := c.MultipartForm()
-	if err != nil {
-		utils.ErrorResponse(c, http.StatusBadRequest, "Failed to parse form data: "+err.Error())
-		return
+	// Files go to the root folder unless folder_id names one of the user's folders.
+	var folderID *uint
+	if raw := c.Query("folder_id"); raw != "" {
+		parsed, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Invalid folder ID")
+			return
+		}
+		id := uint(parsed)
+		folderID = folderRef(&id)
+		if err := h.folderService.CheckFolder(userID.(uint), folderID); err != nil {
+			respondFolderError(c, err)
+			return
+		}
 	}
 
-	files := form.File["files"]
-	if len(files) == 0 {
-		utils.ErrorResponse(c, http.StatusBadRequest, "No files provided")
+	// With encrypted=true the files were encrypted by the client. Each new
+	// file must then be preceded by a wrapped_key field holding its key,
+	// wrapped with the uploader's public key, and optionally an
+	// encryption_metadata field; new versions of encrypted files reuse the
+	// key they already have.
+	encrypted := c.Query("encrypted") == "true"
+	var encryption models.ClientEncryption
+
+	// Read the multipart body part by part so file data is streamed straight
+	// into storage instead of being buffered by the form parser.
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Failed to parse form data: "+err.Error())
 		return
 	}
 
 	var uploadedFiles []map[string]interface{}
 
-	for _, fileHeader := range files {
-		if err := utils.ValidateFileType(fileHeader); err != nil {
-			utils.ErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("Validation failed for %s: %s", fileHeader.Filename, err.Error()))
-			return
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
 		}
-
-		file, err := fileHeader.Open()
 		if err != nil {
-			utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to open uploaded file")
+			utils.ErrorResponse(c, http.StatusBadRequest, "Failed to parse form data: "+err.Error())
 			return
 		}
+		if encrypted && part.FileName() == "" {
+			switch part.FormName() {
+			case "wrapped_key":
+				encryption.WrappedKey, err = readFormField(part)
+			case "encryption_metadata":
+				encryption.Metadata, err = readFormField(part)
+			}
+			part.Close()
+			if err != nil {
+				utils.ErrorResponse(c, http.StatusBadRequest, "Failed to parse form data: "+err.Error())
+				return
+			}
+			continue
+		}
+		if part.FormName() != "files" || part.FileName() == "" {
+			part.Close()
+			continue
+		}
+		filename := part.FileName()
 
-		hash, err := utils.CalculateSHA256Reader(file) // Corrected function name
-		file.Close()
-
+		mimeType, err := uploadMimeType(part.Header.Get("Content-Type"), encrypted)
 		if err != nil {
-			utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to calculate file hash")
+			part.Close()
+			respondUploadError(c, filename, err)
 			return
 		}
-
-		var content models.FileContent
-		err = database.DB.Where("sha256_hash = ?", hash).First(&content).Error
-
-		if err != nil && err != gorm.ErrRecordNotFound {
-			utils.ErrorResponse(c, http.StatusInternalServerError, "Database error checking for file content")
+		content, err := h.fileService.StoreContent(userID.(uint), part, mimeType)
+		part.Close()
+		if err != nil {
+			respondUploadError(c, filename, err)
 			return
 		}
 
-		if err == nil {
-			database.DB.Model(&content).Update("reference_count", gorm.Expr("reference_count + 1"))
-		} else {
-			fileForSave, err := fileHeader.Open()
-			if err != nil {
-				utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to re-open uploaded file for saving")
-				return
-			}
-
-			err = h.storageService.SaveFile(hash, fileForSave)
-			fileForSave.Close()
-
-			if err != nil {
-				utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to save file to storage")
-				return
-			}
-
-			content = models.FileContent{
-				SHA256Hash: hash,
-				FileSize:   fileHeader.Size,
-				MimeType:   fileHeader.Header.Get("Content-Type"),
-			}
-			if err := database.DB.Create(&content).Error; err != nil {
-				h.storageService.Delete(hash)
-				utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to save file metadata")
+		// Uploading over an existing name in the same folder adds a version.
+		var fileEncryption *models.ClientEncryption
+		if encrypted {
+			fileEncryption = &models.ClientEncryption{WrappedKey: encryption.WrappedKey, Metadata: encryption.Metadata}
+			encryption = models.ClientEncryption{}
+		}
+		fileRecord, created, err := h.fileService.SaveUpload(userID.(uint), folderID, filename, content, fileEncryption)
+		if err != nil {
+			if errors.Is(err, services.ErrEncryptionMismatch) || errors.Is(err, services.ErrWrappedKeyRequired) {
+				respondUploadError(c, filename, err)
 				return
 			}
-		}
-
-		fileRecord := &models.File{
-			UserID:           userID.(uint),
-			FileContentID:    content.ID,
-			OriginalFilename: fileHeader.Filename,
-		}
-
-		if err := h.fileService.Create(fileRecord); err != nil {
 			utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to create file record")
 			return
 		}
 
-		h.auditService.Log(c, "UPLOAD", "FILE", &fileRecord.ID, fmt.Sprintf("User uploaded file '%s'", fileHeader.Filename))
+		if created {
+			h.auditService.Log(c, "UPLOAD", "FILE", &fileRecord.ID, fmt.Sprintf("User uploaded file '%s'", filename))
+		} else {
+			h.auditService.Log(c, "UPLOAD", "FILE", &fileRecord.ID, fmt.Sprintf("User uploaded version %d of file '%s'", fileRecord.CurrentVersion, filename))
+		}
 
 		uploadedFiles = append(uploadedFiles, map[string]interface{}{
 			"id":                fileRecord.ID,
-			"original_filename": fileHeader.Filename,
+			"version":           fileRecord.CurrentVersion,
+			"original_filename": filename,
 			"size":              content.FileSize,
 			"mime_type":         content.MimeType,
+			"encrypted":         fileRecord.Encrypted,
 		})
 	}
 
+	if len(uploadedFiles) == 0 {
+		utils.ErrorResponse(c, http.StatusBadRequest, "No files provided")
+		return
+	}
+
 	utils.SuccessResponse(c, fmt.Sprintf("Successfully uploaded %d file(s)", len(uploadedFiles)), gin.H{"files": uploadedFiles})
 }
 
+func respondUploadError(c *gin.Context, filename string, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidFileType), errors.Is(err, services.ErrEmptyFile),
+		errors.Is(err, services.ErrInvalidFileName):
+		utils.ErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("Validation failed for %s: %s", filename, err.Error()))
+	case errors.Is(err, services.ErrFileTooLarge):
+		utils.ErrorResponse(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("%s: %s", filename, err.Error()))
+	case errors.Is(err, services.ErrQuotaExceeded):
+		utils.ErrorResponse(c, http.StatusInsufficientStorage, fmt.Sprintf("Cannot upload %s: %s", filename, err.Error()))
+	case errors.Is(err, services.ErrWrappedKeyRequired):
+		utils.ErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("Cannot upload %s: %s", filename, err.Error()))
+	case errors.Is(err, services.ErrEncryptionMismatch):
+		utils.ErrorResponse(c, http.StatusConflict, fmt.Sprintf("Cannot upload %s: %s", filename, err.Error()))
+	default:
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to save file to storage")
+	}
+}
+
+// uploadMimeType returns the MIME type uploaded content is stored under.
+// Client-encrypted content is always stored as utils.EncryptedMimeType, and
+// plaintext uploads cannot claim that type to get past content sniffing.
+func uploadMimeType(declared string, encrypted bool) (string, error) {
+	if encrypted {
+		return utils.EncryptedMimeType, nil
+	}
+	if mimeType, _, _ := mime.ParseMediaType(declared); mimeType == utils.EncryptedMimeType {
+		return "", fmt.Errorf("%w: encrypted uploads must be sent with encrypted=true", services.ErrInvalidFileType)
+	}
+	return declared, nil
+}
+
+// maxFormFieldSize bounds the plain form fields read from a multipart upload.
+const maxFormFieldSize = 64 << 10
+
+func readFormField(part *multipart.Part) (string, error) {
+	value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(value) > maxFormFieldSize {
+		return "", fmt.Errorf("form field %s is too large", part.FormName())
+	}
+	return string(value), nil
+}
+
 func (h *FileHandler) GetUserFiles(c *gin.Context) {
 	userID, exists := c.Get("userID")
 	if !exists {
@@ -144,144 +220,207 @@ func (h *FileHandler) GetUserFiles(c *gin.Context) {
 		return
 	}
 
-	files, err := h.fileService.GetByUserID(userID.(uint), &filters)
-	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve files: "+err.Error())
-		return
+	if filters.Path != "" {
+		folderID, err := h.folderService.ResolveFolder(userID.(uint), filters.Path)
+		if err != nil {
+			respondFolderError(c, err)
+			return
+		}
+		if folderID == nil {
+			folderID = new(uint)
+		}
+		filters.FolderID = folderID
 	}
 
-	utils.SuccessResponse(c, "Files retrieved successfully", gin.H{"files": files})
-}
+	// When listing a single folder, its subfolders are returned alongside the files.
+	var folders []models.Folder
+	if filters.FolderID != nil {
+		parentID := folderRef(filters.FolderID)
+		if err := h.folderService.CheckFolder(userID.(uint), parentID); err != nil {
+			respondFolderError(c, err)
+			return
+		}
+		var err error
+		if folders, err = h.folderService.List(userID.(uint), parentID); err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve folders: "+err.Error())
+			return
+		}
+	}
 
-func (h *FileHandler) DownloadFile(c *gin.Context) {
-	fileIDStr := c.Param("id")
-	fileID, err := strconv.ParseUint(fileIDStr, 10, 32)
+	page, err := h.fileService.GetByUserID(userID.(uint), &filters)
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid file ID")
+		if errors.Is(err, services.ErrInvalidCursor) {
+			utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve files: "+err.Error())
 		return
 	}
 
-	file, err := h.fileService.GetByID(uint(fileID))
-	if err != nil {
-		utils.ErrorResponse(c, http.StatusNotFound, "File not found")
-		return
+	response := gin.H{
+		"files":       page.Files,
+		"total":       page.Total,
+		"limit":       filters.Limit,
+		"sort":        filters.Sort,
+		"order":       filters.Order,
+		"next_cursor": page.NextCursor,
 	}
+	if filters.Cursor == "" {
+		response["page"] = filters.Page
+		response["total_pages"] = (page.Total + int64(filters.Limit) - 1) / int64(filters.Limit)
+	}
+	if filters.FolderID != nil {
+		response["folders"] = folders
+	}
+	utils.SuccessResponse(c, "Files retrieved successfully", response)
+}
 
-	fileData, err := h.storageService.Get(file.Content.SHA256Hash)
-	if err != nil {
-		utils.ErrorResponse(c, http.StatusNotFound, "File data not found in storage")
+// GetFile returns a file's details along with the permissions the current
+// user holds on it.
+func (h *FileHandler) GetFile(c *gin.Context) {
+	file, perms, ok := h.authorizeFile(c, models.PermissionView)
+	if !ok {
 		return
 	}
 
-	h.fileService.IncrementDownloadCount(file.ID)
-	h.auditService.Log(c, "DOWNLOAD", "FILE", &file.ID, fmt.Sprintf("User downloaded file '%s'", file.OriginalFilename))
-
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", file.OriginalFilename))
-	c.Header("Content-Type", file.Content.MimeType)
-	c.Data(http.StatusOK, file.Content.MimeType, fileData)
+	utils.SuccessResponse(c, "File retrieved successfully", gin.H{"file": file, "permissions": perms.Names()})
 }
 
-func (h *FileHandler) DeleteFile(c *gin.Context) {
-	fileIDStr := c.Param("id")
-	fileID, err := strconv.ParseUint(fileIDStr, 10, 32)
-	if err != nil {
-		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid file ID")
+func (h *FileHandler) DownloadFile(c *gin.Context) {
+	file, _, ok := h.authorizeFile(c, models.PermissionDownload)
+	if !ok {
 		return
 	}
 
-	userID, _ := c.Get("userID")
-
-	file, err := h.fileService.GetByID(uint(fileID))
-	if err != nil {
-		utils.ErrorResponse(c, http.StatusNotFound, "File not found")
-		return
+	if h.streamContent(c, &file.Content, file.OriginalFilename, file.UpdatedAt) {
+		h.fileService.IncrementDownloadCount(file.ID)
+		h.auditService.Log(c, "DOWNLOAD", "FILE", &file.ID, fmt.Sprintf("User downloaded file '%s'", file.OriginalFilename))
 	}
+}
 
-	if file.UserID != userID.(uint) {
-		utils.ErrorResponse(c, http.StatusForbidden, "Access denied: you do not own this file")
+// UpdateFile renames a file and/or moves it to another of its owner's folders.
+func (h *FileHandler) UpdateFile(c *gin.Context) {
+	file, _, ok := h.authorizeFile(c, models.PermissionEdit)
+	if !ok {
 		return
 	}
 
-	err = h.fileService.DeleteFileAndContent(uint(fileID))
-	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to delete file: "+err.Error())
+	var req models.UpdateFileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
 		return
 	}
 
-	h.auditService.Log(c, "DELETE", "FILE", &file.ID, fmt.Sprintf("User deleted file '%s'", file.OriginalFilename))
-	utils.SuccessResponse(c, "File deleted successfully", nil)
-}
-
-func (h *FileHandler) GetStorageStats(c *gin.Context) {
-	userID, exists := c.Get("userID")
-	if !exists {
-		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
-		return
+	if req.OriginalFilename != nil {
+		oldName := file.OriginalFilename
+		if err := h.fileService.Rename(file, *req.OriginalFilename); err != nil {
+			if errors.Is(err, services.ErrInvalidFileName) {
+				utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+				return
+			}
+			utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to rename file: "+err.Error())
+			return
+		}
+		h.auditService.Log(c, "RENAME", "FILE", &file.ID, fmt.Sprintf("User renamed file '%s' to '%s'", oldName, file.OriginalFilename))
 	}
 
-	stats, err := h.fileService.GetStorageStats(userID.(uint))
-	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve storage stats: "+err.Error())
-		return
+	if req.FolderID != nil {
+		if err := h.folderService.MoveFile(file, folderRef(req.FolderID)); err != nil {
+			respondFolderError(c, err)
+			return
+		}
+		h.auditService.Log(c, "MOVE", "FILE", &file.ID, fmt.Sprintf("User moved file '%s' to folder %d", file.OriginalFilename, *req.FolderID))
 	}
 
-	utils.SuccessResponse(c, "Storage stats retrieved successfully", stats)
+	utils.SuccessResponse(c, "File updated successfully", file)
 }
-func (h *FileHandler) ShareFile(c *gin.Context) {
-	userID, _ := c.Get("userID")
-	fileIDStr := c.Param("id")
-	fileID, err := strconv.ParseUint(fileIDStr, 10, 32)
-	if err != nil {
-		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid file ID")
+
+// ReplaceFileContent stores the "file" part of a multipart body as the next
+// version of a file, keeping its name, shares and history. The new content is
+// charged to the file's owner, not to the collaborator uploading it. New
+// content for an encrypted file must be encrypted with the key it already has.
+func (h *FileHandler) ReplaceFileContent(c *gin.Context) {
+	file, _, ok := h.authorizeFile(c, models.PermissionEdit)
+	if !ok {
 		return
 	}
 
-	file, err := h.fileService.GetByID(uint(fileID))
+	reader, err := c.Request.MultipartReader()
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusNotFound, "File not found")
+		utils.ErrorResponse(c, http.StatusBadRequest, "Failed to parse form data: "+err.Error())
 		return
 	}
 
-	if file.UserID != userID.(uint) {
-		utils.ErrorResponse(c, http.StatusForbidden, "Access denied: you do not own this file")
-		return
-	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Failed to parse form data: "+err.Error())
+			return
+		}
+		if part.FormName() != "file" || part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		mimeType, err := uploadMimeType(part.Header.Get("Content-Type"), file.Encrypted)
+		if err != nil {
+			part.Close()
+			respondUploadError(c, file.OriginalFilename, err)
+			return
+		}
+		content, err := h.fileService.StoreContent(file.UserID, part, mimeType)
+		part.Close()
+		if err != nil {
+			respondUploadError(c, file.OriginalFilename, err)
+			return
+		}
 
-	// Toggle the public status
-	file.IsPublic = !file.IsPublic
-	if err := database.DB.Save(file).Error; err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to update file status")
+		userID, _ := c.Get("userID")
+		if _, err := h.fileService.AddVersion(file, content, userID.(uint), nil); err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to replace file content: "+err.Error())
+			return
+		}
+
+		h.auditService.Log(c, "REPLACE", "FILE", &file.ID, fmt.Sprintf("User uploaded version %d of file '%s'", file.CurrentVersion, file.OriginalFilename))
+		utils.SuccessResponse(c, "File content replaced successfully", file)
 		return
 	}
 
-	h.auditService.Log(c, "SHARE", "FILE", &file.ID, fmt.Sprintf("User toggled public access for '%s' to %v", file.OriginalFilename, file.IsPublic))
-	utils.SuccessResponse(c, "File public status updated successfully", file)
+	utils.ErrorResponse(c, http.StatusBadRequest, "No file provided")
 }
 
-func (h *FileHandler) PublicDownload(c *gin.Context) {
-	fileIDStr := c.Param("id")
-	fileID, err := strconv.ParseUint(fileIDStr, 10, 32)
+func (h *FileHandler) DeleteFile(c *gin.Context) {
+	file, _, ok := h.authorizeFile(c, models.PermissionDelete)
+	if !ok {
+		return
+	}
+
+	// Deleting only moves the file to its owner's trash; see TrashHandler.
+	err := h.fileService.Trash(file)
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid file ID")
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to delete file: "+err.Error())
 		return
 	}
 
-	file, err := h.fileService.GetByID(uint(fileID))
-	if err != nil || !file.IsPublic {
-		utils.ErrorResponse(c, http.StatusNotFound, "File not found or is not public")
+	h.auditService.Log(c, "DELETE", "FILE", &file.ID, fmt.Sprintf("User moved file '%s' to the trash", file.OriginalFilename))
+	utils.SuccessResponse(c, "File moved to trash", nil)
+}
+
+func (h *FileHandler) GetStorageStats(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
-	fileData, err := h.storageService.Get(file.Content.SHA256Hash)
+	stats, err := h.fileService.GetStorageStats(userID.(uint))
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusNotFound, "File data not found in storage")
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve storage stats: "+err.Error())
 		return
 	}
 
-	h.fileService.IncrementDownloadCount(file.ID)
-	// Note: We don't log the audit event with a user here, as the download is anonymous.
-	
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", file.OriginalFilename))
-	c.Header("Content-Type", file.Content.MimeType)
-	c.Data(http.StatusOK, file.Content.MimeType, fileData)
-}
\ No newline at end of file
+	utils.SuccessResponse(c, "Storage stats retrieved successfully", stats)
+}
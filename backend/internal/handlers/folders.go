@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/services"
+	"filevault-backend/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type FolderHandler struct {
+	folderService *services.FolderService
+	auditService  *services.AuditService
+}
+
+func NewFolderHandler(folderService *services.FolderService, auditService *services.AuditService) *FolderHandler {
+	return &FolderHandler{
+		folderService: folderService,
+		auditService:  auditService,
+	}
+}
+
+func (h *FolderHandler) CreateFolder(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req models.CreateFolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	folder, err := h.folderService.Create(userID.(uint), folderRef(req.ParentID), req.Name)
+	if err != nil {
+		respondFolderError(c, err)
+		return
+	}
+
+	h.auditService.Log(c, "CREATE", "FOLDER", &folder.ID, fmt.Sprintf("User created folder '%s'", folder.Path))
+	utils.SuccessResponse(c, "Folder created successfully", folder)
+}
+
+// ListFolders lists the folders inside parent_id, or the top-level folders
+// when it is omitted or 0.
+func (h *FolderHandler) ListFolders(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var parentID *uint
+	if raw := c.Query("parent_id"); raw != "" {
+		parsed, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Invalid parent folder ID")
+			return
+		}
+		id := uint(parsed)
+		parentID = folderRef(&id)
+	}
+	if err := h.folderService.CheckFolder(userID.(uint), parentID); err != nil {
+		respondFolderError(c, err)
+		return
+	}
+
+	folders, err := h.folderService.List(userID.(uint), parentID)
+	if err != nil {
+		respondFolderError(c, err)
+		return
+	}
+
+	utils.SuccessResponse(c, "Folders retrieved successfully", gin.H{"folders": folders})
+}
+
+func (h *FolderHandler) GetFolder(c *gin.Context) {
+	folder, ok := h.loadFolder(c)
+	if !ok {
+		return
+	}
+	utils.SuccessResponse(c, "Folder retrieved successfully", folder)
+}
+
+// LookupPath resolves a path such as /Projects/2024/report.pdf to the folder
+// or file it names.
+func (h *FolderHandler) LookupPath(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	folder, file, err := h.folderService.Resolve(userID.(uint), c.Query("path"))
+	if err != nil {
+		if errors.Is(err, services.ErrFolderNotFound) {
+			utils.ErrorResponse(c, http.StatusNotFound, "Nothing found at this path")
+			return
+		}
+		respondFolderError(c, err)
+		return
+	}
+
+	if file != nil {
+		utils.SuccessResponse(c, "File found", gin.H{"type": "file", "file": file})
+		return
+	}
+	utils.SuccessResponse(c, "Folder found", gin.H{"type": "folder", "folder": folder})
+}
+
+// UpdateFolder renames a folder and/or moves it under another parent.
+func (h *FolderHandler) UpdateFolder(c *gin.Context) {
+	folder, ok := h.loadFolder(c)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateFolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	oldPath := folder.Path
+	if req.Name != nil {
+		if err := h.folderService.Rename(folder, *req.Name); err != nil {
+			respondFolderError(c, err)
+			return
+		}
+	}
+	if req.ParentID != nil {
+		if err := h.folderService.Move(folder, folderRef(req.ParentID)); err != nil {
+			respondFolderError(c, err)
+			return
+		}
+	}
+
+	if folder.Path != oldPath {
+		h.auditService.Log(c, "MOVE", "FOLDER", &folder.ID, fmt.Sprintf("User moved folder '%s' to '%s'", oldPath, folder.Path))
+	}
+	utils.SuccessResponse(c, "Folder updated successfully", folder)
+}
+
+// DeleteFolder deletes an empty folder, or a folder and everything in it
+// when recursive=true is passed. Files inside are moved to the trash.
+func (h *FolderHandler) DeleteFolder(c *gin.Context) {
+	folder, ok := h.loadFolder(c)
+	if !ok {
+		return
+	}
+
+	trashedFiles, err := h.folderService.Delete(folder, c.Query("recursive") == "true")
+	if err != nil {
+		respondFolderError(c, err)
+		return
+	}
+
+	h.auditService.Log(c, "DELETE", "FOLDER", &folder.ID, fmt.Sprintf("User deleted folder '%s' and moved %d file(s) to the trash", folder.Path, trashedFiles))
+	utils.SuccessResponse(c, "Folder deleted successfully", gin.H{"trashed_files": trashedFiles})
+}
+
+func (h *FolderHandler) loadFolder(c *gin.Context) (*models.Folder, bool) {
+	userID, _ := c.Get("userID")
+
+	folderID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid folder ID")
+		return nil, false
+	}
+
+	folder, err := h.folderService.Get(userID.(uint), uint(folderID))
+	if err != nil {
+		respondFolderError(c, err)
+		return nil, false
+	}
+	return folder, true
+}
+
+func respondFolderError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrFolderNotFound):
+		utils.ErrorResponse(c, http.StatusNotFound, "Folder not found")
+	case errors.Is(err, services.ErrFolderExists), errors.Is(err, services.ErrFolderNotEmpty):
+		utils.ErrorResponse(c, http.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrFolderCycle), errors.Is(err, services.ErrInvalidFolderName):
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+	default:
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Folder operation failed: "+err.Error())
+	}
+}
+
+// folderRef converts a folder ID from a request, where 0 stands for the root
+// folder, into the nil-for-root form used by the services.
+func folderRef(id *uint) *uint {
+	if id == nil || *id == 0 {
+		return nil
+	}
+	return id
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/services"
+	"filevault-backend/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JobHandler lets admins inspect the background job queue and retry jobs
+// that ended up dead.
+type JobHandler struct {
+	jobService   *services.JobService
+	auditService *services.AuditService
+}
+
+func NewJobHandler(jobService *services.JobService, auditService *services.AuditService) *JobHandler {
+	return &JobHandler{
+		jobService:   jobService,
+		auditService: auditService,
+	}
+}
+
+func (h *JobHandler) ListJobs(c *gin.Context) {
+	var filters models.JobFilters
+	if err := c.ShouldBindQuery(&filters); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	jobs, total, err := h.jobService.List(&filters)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve jobs: "+err.Error())
+		return
+	}
+
+	response := map[string]interface{}{
+		"jobs":        jobs,
+		"total":       total,
+		"page":        filters.Page,
+		"limit":       filters.Limit,
+		"total_pages": (total + int64(filters.Limit) - 1) / int64(filters.Limit),
+	}
+
+	utils.SuccessResponse(c, "Jobs retrieved successfully", response)
+}
+
+func (h *JobHandler) GetJobStats(c *gin.Context) {
+	stats, err := h.jobService.Stats()
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve job stats: "+err.Error())
+		return
+	}
+	utils.SuccessResponse(c, "Job stats retrieved successfully", stats)
+}
+
+func (h *JobHandler) GetJob(c *gin.Context) {
+	jobID, ok := parseJobID(c)
+	if !ok {
+		return
+	}
+
+	job, err := h.jobService.Get(jobID)
+	if err != nil {
+		respondJobError(c, err)
+		return
+	}
+	utils.SuccessResponse(c, "Job retrieved successfully", job)
+}
+
+func (h *JobHandler) RetryJob(c *gin.Context) {
+	jobID, ok := parseJobID(c)
+	if !ok {
+		return
+	}
+
+	job, err := h.jobService.Retry(jobID)
+	if err != nil {
+		respondJobError(c, err)
+		return
+	}
+
+	h.auditService.Log(c, "RETRY", "JOB", &job.ID, fmt.Sprintf("Admin retried dead %s job", job.Type))
+	utils.SuccessResponse(c, "Job queued for retry", job)
+}
+
+func parseJobID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid job ID")
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func respondJobError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrJobNotFound):
+		utils.ErrorResponse(c, http.StatusNotFound, "Job not found")
+	case errors.Is(err, services.ErrJobNotDead):
+		utils.ErrorResponse(c, http.StatusConflict, err.Error())
+	default:
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to process job: "+err.Error())
+	}
+}
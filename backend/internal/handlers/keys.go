@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/services"
+	"filevault-backend/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UpdatePublicKey publishes the current user's public key, which others use
+// to wrap the keys of encrypted files they share with them.
+func (h *AuthHandler) UpdatePublicKey(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req models.UpdatePublicKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	user, err := h.authService.SetPublicKey(userID.(uint), req.PublicKey, req.Algorithm)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to update public key")
+		return
+	}
+
+	utils.SuccessResponse(c, "Public key updated successfully", gin.H{
+		"public_key":           user.PublicKey,
+		"public_key_algorithm": user.PublicKeyAlgorithm,
+	})
+}
+
+// GetPublicKey returns the public key of the user named by the user query
+// parameter, a username or email, so a file key can be wrapped for them
+// before sharing.
+func (h *AuthHandler) GetPublicKey(c *gin.Context) {
+	identifier := c.Query("user")
+	if identifier == "" {
+		utils.ErrorResponse(c, http.StatusBadRequest, "user query parameter is required")
+		return
+	}
+
+	user, err := h.authService.FindPublicKey(identifier)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUserNotFound):
+			utils.ErrorResponse(c, http.StatusNotFound, "User not found")
+		case errors.Is(err, services.ErrNoPublicKey):
+			utils.ErrorResponse(c, http.StatusNotFound, err.Error())
+		default:
+			utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve public key")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Public key retrieved successfully", gin.H{
+		"id":                   user.ID,
+		"username":             user.Username,
+		"public_key":           user.PublicKey,
+		"public_key_algorithm": user.PublicKeyAlgorithm,
+	})
+}
+
+// GetFileKey returns the current user's copy of the key of an encrypted file,
+// together with the parameters the client stored alongside it.
+func (h *FileHandler) GetFileKey(c *gin.Context) {
+	file, _, ok := h.authorizeFile(c, models.PermissionView)
+	if !ok {
+		return
+	}
+	userID, _ := c.Get("userID")
+
+	key, err := h.fileService.GetFileKey(file, userID.(uint))
+	if err != nil {
+		respondFileKeyError(c, err)
+		return
+	}
+
+	utils.SuccessResponse(c, "File key retrieved successfully", gin.H{
+		"file_id":             file.ID,
+		"wrapped_key":         key.WrappedKey,
+		"encryption_metadata": file.EncryptionMetadata,
+	})
+}
+
+// UpdateFileKey replaces the current user's copy of the key of an encrypted
+// file, which clients do after rewrapping it for a new public key.
+func (h *FileHandler) UpdateFileKey(c *gin.Context) {
+	file, _, ok := h.authorizeFile(c, models.PermissionView)
+	if !ok {
+		return
+	}
+	userID, _ := c.Get("userID")
+
+	var req models.UpdateFileKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	key, err := h.fileService.SetFileKey(file, userID.(uint), req.WrappedKey)
+	if err != nil {
+		respondFileKeyError(c, err)
+		return
+	}
+
+	h.auditService.Log(c, "UPDATE_KEY", "FILE", &file.ID, fmt.Sprintf("User replaced their key for '%s'", file.OriginalFilename))
+	utils.SuccessResponse(c, "File key updated successfully", key)
+}
+
+func respondFileKeyError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrNotEncrypted):
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrFileKeyNotFound):
+		utils.ErrorResponse(c, http.StatusNotFound, err.Error())
+	default:
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to access file key: "+err.Error())
+	}
+}
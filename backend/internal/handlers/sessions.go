@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"filevault-backend/internal/services"
+	"filevault-backend/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SessionHandler lets users see where they are logged in and log out
+// sessions they no longer trust, such as one on a lost device.
+type SessionHandler struct {
+	sessionService *services.SessionService
+	auditService   *services.AuditService
+}
+
+func NewSessionHandler(sessionService *services.SessionService, auditService *services.AuditService) *SessionHandler {
+	return &SessionHandler{
+		sessionService: sessionService,
+		auditService:   auditService,
+	}
+}
+
+func (h *SessionHandler) ListSessions(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	sessionID := c.GetString("sessionID")
+
+	sessions, err := h.sessionService.List(userID.(uint))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve sessions: "+err.Error())
+		return
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == sessionID
+	}
+
+	utils.SuccessResponse(c, "Sessions retrieved successfully", gin.H{"sessions": sessions})
+}
+
+// TerminateSession logs out one of the current user's sessions. Terminating
+// the current session is the same as logging out.
+func (h *SessionHandler) TerminateSession(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	if err := h.sessionService.Terminate(userID.(uint), c.Param("id")); err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			utils.ErrorResponse(c, http.StatusNotFound, "Session not found")
+			return
+		}
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to terminate session: "+err.Error())
+		return
+	}
+
+	h.auditService.Log(c, "TERMINATE_SESSION", "SESSION", nil, fmt.Sprintf("User terminated session %s", c.Param("id")))
+	utils.SuccessResponse(c, "Session terminated successfully", nil)
+}
+
+// TerminateOtherSessions logs the current user out everywhere except for the
+// session making the request.
+func (h *SessionHandler) TerminateOtherSessions(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	terminated, err := h.sessionService.TerminateAll(userID.(uint), c.GetString("sessionID"))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to terminate sessions: "+err.Error())
+		return
+	}
+
+	h.auditService.Log(c, "TERMINATE_SESSION", "SESSION", nil, fmt.Sprintf("User terminated %d other session(s)", terminated))
+	utils.SuccessResponse(c, "Sessions terminated successfully", gin.H{"terminated": terminated})
+}
@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/services"
+	"filevault-backend/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ShareFile creates a public share link for a file.
+func (h *FileHandler) ShareFile(c *gin.Context) {
+	file, _, ok := h.authorizeFile(c, models.PermissionReshare)
+	if !ok {
+		return
+	}
+	userID, _ := c.Get("userID")
+
+	var req models.CreateShareRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.ValidationErrorResponse(c, err)
+			return
+		}
+	}
+
+	expiresAt := req.ExpiresAt
+	if req.ExpiresInHours > 0 {
+		t := time.Now().Add(time.Duration(req.ExpiresInHours) * time.Hour)
+		expiresAt = &t
+	}
+	if expiresAt != nil && expiresAt.Before(time.Now()) {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Expiry time must be in the future")
+		return
+	}
+
+	share, err := h.shareService.CreateLink(file, userID.(uint), services.ShareLinkOptions{
+		ExpiresAt:    expiresAt,
+		Password:     req.Password,
+		MaxDownloads: req.MaxDownloads,
+	})
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to create share link")
+		return
+	}
+	share.ShareURL = shareURL(share)
+
+	h.auditService.Log(c, "SHARE", "FILE", &file.ID, fmt.Sprintf("User created a share link for '%s'", file.OriginalFilename))
+	utils.SuccessResponse(c, "Share link created successfully", share)
+}
+
+func (h *FileHandler) ListShares(c *gin.Context) {
+	file, _, ok := h.authorizeFile(c, models.PermissionReshare)
+	if !ok {
+		return
+	}
+
+	shares, err := h.shareService.ListLinks(file.ID)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve share links")
+		return
+	}
+	for i := range shares {
+		shares[i].ShareURL = shareURL(&shares[i])
+	}
+
+	utils.SuccessResponse(c, "Share links retrieved successfully", gin.H{"shares": shares})
+}
+
+func (h *FileHandler) RevokeShare(c *gin.Context) {
+	file, _, ok := h.authorizeFile(c, models.PermissionReshare)
+	if !ok {
+		return
+	}
+
+	shareID, err := strconv.ParseUint(c.Param("shareId"), 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid share ID")
+		return
+	}
+
+	if err := h.shareService.RevokeLink(file.ID, uint(shareID), grantedBy(c, file)); err != nil {
+		if errors.Is(err, services.ErrShareNotFound) {
+			utils.ErrorResponse(c, http.StatusNotFound, "Share link not found")
+			return
+		}
+		if errors.Is(err, services.ErrNotGrantor) {
+			utils.ErrorResponse(c, http.StatusForbidden, "You can only revoke share links you created")
+			return
+		}
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to revoke share link")
+		return
+	}
+
+	h.auditService.Log(c, "UNSHARE", "FILE", &file.ID, fmt.Sprintf("User revoked a share link for '%s'", file.OriginalFilename))
+	utils.SuccessResponse(c, "Share link revoked successfully", nil)
+}
+
+// ShareWithUser grants another registered user access to a file. The grant is
+// either a role ("viewer" or "editor") or an explicit list of permissions, and
+// defaults to viewer. Collaborators holding reshare can only pass on
+// permissions they hold themselves, and only change grants they made. Sharing an encrypted file also takes its
+// key, wrapped by the client with the recipient's public key.
+func (h *FileHandler) ShareWithUser(c *gin.Context) {
+	file, held, ok := h.authorizeFile(c, models.PermissionReshare)
+	if !ok {
+		return
+	}
+	userID, _ := c.Get("userID")
+
+	var req models.ShareWithUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Expiry time must be in the future")
+		return
+	}
+
+	perms := models.ShareRoles["viewer"]
+	if req.Role != "" {
+		perms = models.ShareRoles[req.Role]
+	} else if len(req.Permissions) > 0 {
+		var valid bool
+		if perms, valid = models.ParsePermissions(req.Permissions); !valid {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Unknown permission; valid permissions are view, download, edit, reshare and delete")
+			return
+		}
+	}
+	// Anyone who can do anything with a file can see it.
+	perms |= models.PermissionView
+	if !held.Has(perms) {
+		utils.ErrorResponse(c, http.StatusForbidden, "You cannot grant permissions you do not hold")
+		return
+	}
+
+	share, err := h.shareService.ShareWithUser(file, userID.(uint), grantedBy(c, file) == nil, req.User, perms, req.ExpiresAt, req.WrappedKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUserNotFound):
+			utils.ErrorResponse(c, http.StatusNotFound, "User not found")
+		case errors.Is(err, services.ErrShareWithSelf), errors.Is(err, services.ErrWrappedKeyRequired):
+			utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		case errors.Is(err, services.ErrNoPublicKey):
+			utils.ErrorResponse(c, http.StatusConflict, "The recipient has not published a public key, so encrypted files cannot be shared with them")
+		case errors.Is(err, services.ErrNotGrantor):
+			utils.ErrorResponse(c, http.StatusForbidden, "You can only change shares you created")
+		default:
+			utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to share file")
+		}
+		return
+	}
+
+	h.auditService.Log(c, "SHARE", "FILE", &file.ID, fmt.Sprintf("User shared '%s' with '%s' (%s)", file.OriginalFilename, share.SharedWith.Username, strings.Join(share.Grants, ", ")))
+	utils.SuccessResponse(c, "File shared successfully", share)
+}
+
+func (h *FileHandler) ListUserShares(c *gin.Context) {
+	file, _, ok := h.authorizeFile(c, models.PermissionReshare)
+	if !ok {
+		return
+	}
+
+	shares, err := h.shareService.ListUserShares(file.ID)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve shares")
+		return
+	}
+
+	utils.SuccessResponse(c, "Shares retrieved successfully", gin.H{"shares": shares})
+}
+
+func (h *FileHandler) RevokeUserShare(c *gin.Context) {
+	file, _, ok := h.authorizeFile(c, models.PermissionReshare)
+	if !ok {
+		return
+	}
+
+	recipientID, err := strconv.ParseUint(c.Param("userId"), 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if err := h.shareService.RevokeUserShare(file.ID, uint(recipientID), grantedBy(c, file)); err != nil {
+		if errors.Is(err, services.ErrShareNotFound) {
+			utils.ErrorResponse(c, http.StatusNotFound, "File is not shared with this user")
+			return
+		}
+		if errors.Is(err, services.ErrNotGrantor) {
+			utils.ErrorResponse(c, http.StatusForbidden, "You can only revoke shares you created")
+			return
+		}
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to revoke share")
+		return
+	}
+
+	h.auditService.Log(c, "UNSHARE", "FILE", &file.ID, fmt.Sprintf("User stopped sharing '%s' with user %d", file.OriginalFilename, recipientID))
+	utils.SuccessResponse(c, "Share revoked successfully", nil)
+}
+
+// GetSharedWithMe lists the files other users have shared with the current user.
+func (h *FileHandler) GetSharedWithMe(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	files, err := h.shareService.SharedWithUser(userID.(uint))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve shared files: "+err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Shared files retrieved successfully", gin.H{"files": files})
+}
+
+// PublicDownload serves a file through a share link token, without
+// authentication. Password-protected links take the password from the
+// X-Share-Password header, never from the URL, where it would end up in logs
+// and browser history.
+func (h *FileHandler) PublicDownload(c *gin.Context) {
+	share, err := h.shareService.ResolveLink(c.Param("token"), c.GetHeader("X-Share-Password"))
+	reserved := false
+	if err == nil && share.MaxDownloads != nil && c.Request.Method != http.MethodHead {
+		// Reserve the download before sending anything, so a limited link
+		// cannot be fetched more often than allowed by parallel requests.
+		// Every request that gets data counts, whichever range it asks for,
+		// so a file cannot be fetched piece by piece past the limit.
+		err = h.shareService.ConsumeDownload(share)
+		reserved = err == nil
+	}
+	if err != nil {
+		respondShareError(c, err)
+		return
+	}
+
+	file := share.File
+	fresh := h.streamContent(c, &file.Content, file.OriginalFilename, file.UpdatedAt)
+	if reserved && !servedContent(c) {
+		// Nothing was sent, because the file is being scanned, is missing,
+		// the range cannot be satisfied or the client's copy is current, so
+		// the reservation is given back.
+		h.shareService.RefundDownload(share)
+	}
+	if !fresh {
+		return
+	}
+	if share.MaxDownloads == nil {
+		h.shareService.ConsumeDownload(share)
+	}
+	h.fileService.IncrementDownloadCount(file.ID)
+	// Note: We don't log the audit event with a user here, as the download is anonymous.
+}
+
+// grantedBy is the user whose shares the caller may change, or nil when the
+// caller owns the file, or is an admin, and may change any of them.
+func grantedBy(c *gin.Context, file *models.File) *uint {
+	userID, _ := c.Get("userID")
+	isAdmin, _ := c.Get("isAdmin")
+	if file.UserID == userID.(uint) || isAdmin.(bool) {
+		return nil
+	}
+	id := userID.(uint)
+	return &id
+}
+
+func respondShareError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrShareNotFound):
+		utils.ErrorResponse(c, http.StatusNotFound, "Share link not found or has been revoked")
+	case errors.Is(err, services.ErrShareExpired):
+		utils.ErrorResponse(c, http.StatusGone, "Share link has expired")
+	case errors.Is(err, services.ErrShareExhausted):
+		utils.ErrorResponse(c, http.StatusGone, "Share link has reached its download limit")
+	case errors.Is(err, services.ErrSharePasswordRequired):
+		utils.ErrorResponse(c, http.StatusUnauthorized, "This share link requires a password")
+	case errors.Is(err, services.ErrSharePasswordInvalid):
+		utils.ErrorResponse(c, http.StatusForbidden, "Incorrect password for this share link")
+	default:
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to resolve share link")
+	}
+}
+
+func shareURL(share *models.FileShare) string {
+	if share.Token == nil {
+		return ""
+	}
+	return "/api/v1/public/shares/" + *share.Token + "/download"
+}
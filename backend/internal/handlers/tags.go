@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/services"
+	"filevault-backend/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TagHandler struct {
+	tagService   *services.TagService
+	auditService *services.AuditService
+}
+
+func NewTagHandler(tagService *services.TagService, auditService *services.AuditService) *TagHandler {
+	return &TagHandler{
+		tagService:   tagService,
+		auditService: auditService,
+	}
+}
+
+// ListTags lists the user's tags with the number of files carrying each.
+func (h *TagHandler) ListTags(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	tags, err := h.tagService.List(userID.(uint))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve tags: "+err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Tags retrieved successfully", gin.H{"tags": tags})
+}
+
+func (h *TagHandler) RenameTag(c *gin.Context) {
+	tag, ok := h.loadTag(c)
+	if !ok {
+		return
+	}
+
+	var req models.RenameTagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	oldName := tag.Name
+	if err := h.tagService.Rename(tag, req.Name); err != nil {
+		respondTagError(c, err)
+		return
+	}
+
+	h.auditService.Log(c, "RENAME", "TAG", &tag.ID, fmt.Sprintf("User renamed tag '%s' to '%s'", oldName, tag.Name))
+	utils.SuccessResponse(c, "Tag renamed successfully", tag)
+}
+
+func (h *TagHandler) DeleteTag(c *gin.Context) {
+	tag, ok := h.loadTag(c)
+	if !ok {
+		return
+	}
+
+	if err := h.tagService.Delete(tag); err != nil {
+		respondTagError(c, err)
+		return
+	}
+
+	h.auditService.Log(c, "DELETE", "TAG", &tag.ID, fmt.Sprintf("User deleted tag '%s'", tag.Name))
+	utils.SuccessResponse(c, "Tag deleted successfully", nil)
+}
+
+func (h *TagHandler) loadTag(c *gin.Context) (*models.Tag, bool) {
+	userID, _ := c.Get("userID")
+
+	tagID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid tag ID")
+		return nil, false
+	}
+
+	tag, err := h.tagService.Get(userID.(uint), uint(tagID))
+	if err != nil {
+		respondTagError(c, err)
+		return nil, false
+	}
+	return tag, true
+}
+
+// TagFile adds tags to a file, creating them as needed. Tags live in the file
+// owner's namespace, so collaborators with edit permission tag with the
+// owner's tags.
+func (h *FileHandler) TagFile(c *gin.Context) {
+	file, _, ok := h.authorizeFile(c, models.PermissionEdit)
+	if !ok {
+		return
+	}
+
+	var req models.TagFileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	if err := h.tagService.AddToFile(file, req.Tags); err != nil {
+		respondTagError(c, err)
+		return
+	}
+
+	h.auditService.Log(c, "TAG", "FILE", &file.ID, fmt.Sprintf("User tagged file '%s'", file.OriginalFilename))
+	utils.SuccessResponse(c, "File tagged successfully", gin.H{"tags": file.Tags})
+}
+
+func (h *FileHandler) UntagFile(c *gin.Context) {
+	file, _, ok := h.authorizeFile(c, models.PermissionEdit)
+	if !ok {
+		return
+	}
+
+	if err := h.tagService.RemoveFromFile(file, c.Param("tag")); err != nil {
+		respondTagError(c, err)
+		return
+	}
+
+	h.auditService.Log(c, "UNTAG", "FILE", &file.ID, fmt.Sprintf("User removed tag '%s' from file '%s'", c.Param("tag"), file.OriginalFilename))
+	utils.SuccessResponse(c, "Tag removed successfully", gin.H{"tags": file.Tags})
+}
+
+func respondTagError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrTagNotFound):
+		utils.ErrorResponse(c, http.StatusNotFound, "Tag not found")
+	case errors.Is(err, services.ErrTagExists):
+		utils.ErrorResponse(c, http.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrInvalidTagName):
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+	default:
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Tag operation failed: "+err.Error())
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/services"
+	"filevault-backend/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetThumbnail serves a scaled-down preview of an image file. Previews only
+// need view access and are not counted as downloads.
+func (h *FileHandler) GetThumbnail(c *gin.Context) {
+	file, _, ok := h.authorizeFile(c, models.PermissionView)
+	if !ok {
+		return
+	}
+
+	size := c.DefaultQuery("size", services.DefaultThumbnailSize)
+	reader, err := h.fileService.OpenThumbnail(&file.Content, size)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidThumbnailSize):
+			utils.ErrorResponse(c, http.StatusBadRequest, "Invalid thumbnail size; use small, medium or large")
+		case errors.Is(err, services.ErrNoThumbnail):
+			utils.ErrorResponse(c, http.StatusNotFound, "No thumbnail available for this file")
+		default:
+			utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to load thumbnail: "+err.Error())
+		}
+		return
+	}
+	defer reader.Close()
+
+	// The rendition changes only with the content, so the content hash and
+	// size make a strong validator. The type is sniffed by ServeContent.
+	header := c.Writer.Header()
+	header.Set("ETag", `"`+file.Content.SHA256Hash+"-"+size+`"`)
+	header.Set("Cache-Control", "private, no-cache")
+
+	http.ServeContent(c.Writer, c.Request, "", file.UpdatedAt, reader)
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/services"
+	"filevault-backend/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TrashHandler serves a user's trash: files deleted through DeleteFile stay
+// here until they are restored, purged by hand, or purged automatically once
+// the retention period has passed.
+type TrashHandler struct {
+	fileService  *services.FileService
+	auditService *services.AuditService
+}
+
+func NewTrashHandler(fileService *services.FileService, auditService *services.AuditService) *TrashHandler {
+	return &TrashHandler{
+		fileService:  fileService,
+		auditService: auditService,
+	}
+}
+
+func (h *TrashHandler) ListTrash(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	files, err := h.fileService.ListTrash(userID.(uint))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve trash: "+err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Trash retrieved successfully", gin.H{"files": files})
+}
+
+func (h *TrashHandler) RestoreFile(c *gin.Context) {
+	file, ok := h.loadTrashed(c)
+	if !ok {
+		return
+	}
+
+	if err := h.fileService.RestoreFromTrash(file); err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to restore file: "+err.Error())
+		return
+	}
+
+	h.auditService.Log(c, "RESTORE", "FILE", &file.ID, fmt.Sprintf("User restored file '%s' from the trash", file.OriginalFilename))
+	utils.SuccessResponse(c, "File restored successfully", file)
+}
+
+// PurgeFile permanently deletes a single file from the trash.
+func (h *TrashHandler) PurgeFile(c *gin.Context) {
+	file, ok := h.loadTrashed(c)
+	if !ok {
+		return
+	}
+
+	if err := h.fileService.DeleteFileAndContent(file.ID); err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to delete file: "+err.Error())
+		return
+	}
+
+	h.auditService.Log(c, "PURGE", "FILE", &file.ID, fmt.Sprintf("User permanently deleted file '%s'", file.OriginalFilename))
+	utils.SuccessResponse(c, "File permanently deleted", nil)
+}
+
+func (h *TrashHandler) EmptyTrash(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	purged, err := h.fileService.EmptyTrash(userID.(uint))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to empty trash: "+err.Error())
+		return
+	}
+
+	h.auditService.Log(c, "PURGE", "FILE", nil, fmt.Sprintf("User emptied the trash, permanently deleting %d file(s)", purged))
+	utils.SuccessResponse(c, "Trash emptied successfully", gin.H{"deleted_files": purged})
+}
+
+func (h *TrashHandler) loadTrashed(c *gin.Context) (*models.File, bool) {
+	userID, _ := c.Get("userID")
+
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid file ID")
+		return nil, false
+	}
+
+	file, err := h.fileService.GetTrashed(userID.(uint), uint(fileID))
+	if err != nil {
+		if errors.Is(err, services.ErrNotInTrash) {
+			utils.ErrorResponse(c, http.StatusNotFound, "File not found in trash")
+			return nil, false
+		}
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve file: "+err.Error())
+		return nil, false
+	}
+	return file, true
+}
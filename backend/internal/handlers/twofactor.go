@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/services"
+	"filevault-backend/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VerifyTwoFactor completes a login that returned a two-factor challenge,
+// with a code from the user's authenticator or one of their recovery codes.
+func (h *AuthHandler) VerifyTwoFactor(c *gin.Context) {
+	var req models.TwoFactorLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	user, err := h.twoFactorService.CompleteChallenge(req.Challenge, req.Code)
+	if err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+
+	tokens, err := h.authService.IssueTokens(user, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	utils.SuccessResponse(c, "Login successful", loginResponse(tokens, user))
+}
+
+// EnrollTwoFactor starts setting up two-factor authentication, which needs
+// the user's password. The response carries the secret and its otpauth://
+// URI for the client to show as a QR code; it takes effect once
+// ConfirmTwoFactor receives a matching code.
+func (h *AuthHandler) EnrollTwoFactor(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req models.TwoFactorEnrollRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	enrollment, err := h.twoFactorService.Enroll(userID.(uint), req.Password)
+	if err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+
+	utils.SuccessResponse(c, "Scan the QR code with your authenticator app and confirm with a code", enrollment)
+}
+
+// ConfirmTwoFactor turns two-factor authentication on and returns the
+// recovery codes, which are not shown again. The user's other sessions are
+// logged out.
+func (h *AuthHandler) ConfirmTwoFactor(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req models.TwoFactorConfirmRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	codes, err := h.twoFactorService.Confirm(userID.(uint), c.GetString("sessionID"), req.Password, req.Code)
+	if err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+
+	id := userID.(uint)
+	h.auditService.Log(c, "ENABLE_2FA", "USER", &id, "User enabled two-factor authentication")
+	utils.SuccessResponse(c, "Two-factor authentication enabled", gin.H{"recovery_codes": codes})
+}
+
+func (h *AuthHandler) DisableTwoFactor(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req models.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	if err := h.twoFactorService.Disable(userID.(uint), req.Code); err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+
+	id := userID.(uint)
+	h.auditService.Log(c, "DISABLE_2FA", "USER", &id, "User disabled two-factor authentication")
+	utils.SuccessResponse(c, "Two-factor authentication disabled", nil)
+}
+
+// RegenerateRecoveryCodes replaces the current user's recovery codes, for
+// example once most of them have been used.
+func (h *AuthHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req models.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	codes, err := h.twoFactorService.RegenerateRecoveryCodes(userID.(uint), req.Code)
+	if err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+
+	id := userID.(uint)
+	h.auditService.Log(c, "REGENERATE_RECOVERY_CODES", "USER", &id, "User generated new recovery codes")
+	utils.SuccessResponse(c, "Recovery codes regenerated", gin.H{"recovery_codes": codes})
+}
+
+func (h *AdminHandler) GetTwoFactorPolicy(c *gin.Context) {
+	required, err := h.twoFactorService.AdminsRequired()
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve two-factor policy")
+		return
+	}
+
+	utils.SuccessResponse(c, "Two-factor policy retrieved successfully", gin.H{"require_for_admins": required})
+}
+
+// UpdateTwoFactorPolicy sets whether admins need two-factor authentication
+// to use the admin API. An admin can only require it after enabling it on
+// their own account, so they cannot lock themselves out.
+func (h *AdminHandler) UpdateTwoFactorPolicy(c *gin.Context) {
+	var req models.TwoFactorPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	if *req.RequireForAdmins && !c.GetBool("twoFactor") {
+		utils.ErrorResponse(c, http.StatusConflict, "Enable two-factor authentication on your own account first")
+		return
+	}
+	if err := h.twoFactorService.SetAdminsRequired(*req.RequireForAdmins); err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to update two-factor policy")
+		return
+	}
+
+	h.auditService.Log(c, "UPDATE_2FA_POLICY", "SETTING", nil, fmt.Sprintf("Admin set two-factor requirement for admins to %v", *req.RequireForAdmins))
+	utils.SuccessResponse(c, "Two-factor policy updated successfully", gin.H{"require_for_admins": *req.RequireForAdmins})
+}
+
+func respondTwoFactorError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidChallenge):
+		utils.ErrorResponse(c, http.StatusUnauthorized, err.Error())
+	case errors.Is(err, services.ErrInvalidTwoFactorCode), errors.Is(err, services.ErrIncorrectPassword):
+		// Not a 401, which would read as the session having expired.
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrAccountSuspended), errors.Is(err, services.ErrTwoFactorRequired):
+		utils.ErrorResponse(c, http.StatusForbidden, err.Error())
+	case errors.Is(err, services.ErrTwoFactorEnabled), errors.Is(err, services.ErrTwoFactorNotEnrolled),
+		errors.Is(err, services.ErrTwoFactorNotEnabled):
+		utils.ErrorResponse(c, http.StatusConflict, err.Error())
+	default:
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Two-factor authentication failed")
+	}
+}
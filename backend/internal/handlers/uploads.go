@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/services"
+	"filevault-backend/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,checksum,expiration"
+)
+
+// UploadHandler serves resumable uploads following the tus 1.0 protocol
+// (https://tus.io/protocols/resumable-upload).
+type UploadHandler struct {
+	uploadService *services.UploadService
+	folderService *services.FolderService
+	auditService  *services.AuditService
+}
+
+func NewUploadHandler(uploadService *services.UploadService, folderService *services.FolderService, auditService *services.AuditService) *UploadHandler {
+	return &UploadHandler{
+		uploadService: uploadService,
+		folderService: folderService,
+		auditService:  auditService,
+	}
+}
+
+// TusResumable checks the protocol version of every tus request and stamps
+// it on the response.
+func (h *UploadHandler) TusResumable() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodOptions {
+			c.Next()
+			return
+		}
+		c.Header("Tus-Resumable", tusVersion)
+		if c.GetHeader("Tus-Resumable") != tusVersion {
+			c.Header("Tus-Version", tusVersion)
+			utils.ErrorResponse(c, http.StatusPreconditionFailed, "Unsupported tus version")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func (h *UploadHandler) Options(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Checksum-Algorithm", strings.Join(services.SupportedChecksumAlgorithms, ","))
+	if max := h.uploadService.MaxSize(); max > 0 {
+		c.Header("Tus-Max-Size", strconv.FormatInt(max, 10))
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *UploadHandler) Create(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Upload-Length header is required")
+		return
+	}
+
+	rawMetadata := c.GetHeader("Upload-Metadata")
+	metadata := parseUploadMetadata(rawMetadata)
+	filename := metadata["filename"]
+	if filename == "" {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Upload-Metadata must include a filename")
+		return
+	}
+	mimeType := metadata["filetype"]
+	if mimeType == "" {
+		mimeType = metadata["content_type"]
+	}
+
+	// Client-encrypted uploads set "encrypted" to "true" and pass the file key
+	// in "wrapped_key", just like the encrypted form of a regular upload.
+	var encryption *models.ClientEncryption
+	if metadata["encrypted"] == "true" {
+		encryption = &models.ClientEncryption{
+			WrappedKey: metadata["wrapped_key"],
+			Metadata:   metadata["encryption_metadata"],
+		}
+	}
+	mimeType, err = uploadMimeType(mimeType, encryption != nil)
+	if err != nil {
+		respondUploadError(c, filename, err)
+		return
+	}
+
+	var folderID *uint
+	if raw := metadata["folder_id"]; raw != "" {
+		parsed, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Invalid folder ID")
+			return
+		}
+		id := uint(parsed)
+		folderID = folderRef(&id)
+		if err := h.folderService.CheckFolder(userID.(uint), folderID); err != nil {
+			respondFolderError(c, err)
+			return
+		}
+	}
+
+	session, err := h.uploadService.Create(userID.(uint), folderID, length, filename, mimeType, rawMetadata, encryption)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.Header("Location", strings.TrimSuffix(c.Request.URL.Path, "/")+"/"+session.ID)
+	c.Header("Upload-Offset", "0")
+	setUploadExpires(c, session)
+	c.Status(http.StatusCreated)
+}
+
+func (h *UploadHandler) Head(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	session, err := h.uploadService.Get(c.Param("uploadId"), userID.(uint))
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(session.Length, 10))
+	setUploadExpires(c, session)
+	if session.Metadata != "" {
+		c.Header("Upload-Metadata", session.Metadata)
+	}
+	c.Status(http.StatusOK)
+}
+
+func (h *UploadHandler) Patch(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	if c.ContentType() != "application/offset+octet-stream" {
+		utils.ErrorResponse(c, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream")
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Upload-Offset header is required")
+		return
+	}
+
+	session, err := h.uploadService.Get(c.Param("uploadId"), userID.(uint))
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	newOffset, err := h.uploadService.Append(session, offset, c.Request.Body, c.GetHeader("Upload-Checksum"))
+	if errors.Is(err, services.ErrInvalidFileType) {
+		h.uploadService.Terminate(session)
+		respondUploadError(c, session.Filename, err)
+		return
+	}
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	if h.uploadService.IsComplete(session) {
+		file, err := h.uploadService.Finish(session)
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidFileType) || errors.Is(err, services.ErrEmptyFile) ||
+				errors.Is(err, services.ErrFileTooLarge) || errors.Is(err, services.ErrQuotaExceeded) ||
+				errors.Is(err, services.ErrEncryptionMismatch) || errors.Is(err, services.ErrWrappedKeyRequired) ||
+				errors.Is(err, services.ErrInvalidFileName) {
+				// The assembled data can never become a valid file, so drop it.
+				h.uploadService.Terminate(session)
+				respondUploadError(c, session.Filename, err)
+				return
+			}
+			h.respondError(c, err)
+			return
+		}
+		h.auditService.Log(c, "UPLOAD", "FILE", &file.ID, fmt.Sprintf("User uploaded file '%s' (resumable)", file.OriginalFilename))
+		c.Header("Upload-File-Id", strconv.FormatUint(uint64(file.ID), 10))
+	} else {
+		setUploadExpires(c, session)
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	c.Status(http.StatusNoContent)
+}
+
+func (h *UploadHandler) Terminate(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	session, err := h.uploadService.Get(c.Param("uploadId"), userID.(uint))
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+	if err := h.uploadService.Terminate(session); err != nil {
+		h.respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *UploadHandler) respondError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrUploadNotFound):
+		utils.ErrorResponse(c, http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrUploadExpired):
+		utils.ErrorResponse(c, http.StatusGone, err.Error())
+	case errors.Is(err, services.ErrUploadOffset), errors.Is(err, services.ErrUploadComplete):
+		utils.ErrorResponse(c, http.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrUploadLocked):
+		utils.ErrorResponse(c, http.StatusLocked, err.Error())
+	case errors.Is(err, services.ErrFileTooLarge), errors.Is(err, services.ErrUploadInvalidChunk):
+		utils.ErrorResponse(c, http.StatusRequestEntityTooLarge, err.Error())
+	case errors.Is(err, services.ErrQuotaExceeded):
+		utils.ErrorResponse(c, http.StatusInsufficientStorage, err.Error())
+	case errors.Is(err, services.ErrChecksumAlgorithm), errors.Is(err, services.ErrInvalidFileName):
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrChecksumMismatch):
+		// 460 is the status the tus checksum extension defines for this case.
+		utils.ErrorResponse(c, 460, err.Error())
+	default:
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Upload failed: "+err.Error())
+	}
+}
+
+// setUploadExpires tells the client until when an unfinished upload can be
+// resumed.
+func setUploadExpires(c *gin.Context, session *models.UploadSession) {
+	if session.FileID == nil && session.ExpiresAt != nil {
+		c.Header("Upload-Expires", session.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// parseUploadMetadata decodes a tus Upload-Metadata header: comma-separated
+// "key base64value" pairs, where the value may be omitted.
+func parseUploadMetadata(header string) map[string]string {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			continue
+		}
+		metadata[key] = string(value)
+	}
+	return metadata
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/services"
+	"filevault-backend/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *FileHandler) ListVersions(c *gin.Context) {
+	file, _, ok := h.authorizeFile(c, models.PermissionView)
+	if !ok {
+		return
+	}
+
+	versions, err := h.fileService.ListVersions(file.ID)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve versions: "+err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Versions retrieved successfully", gin.H{"current_version": file.CurrentVersion, "versions": versions})
+}
+
+func (h *FileHandler) DownloadVersion(c *gin.Context) {
+	file, _, ok := h.authorizeFile(c, models.PermissionDownload)
+	if !ok {
+		return
+	}
+	version, ok := h.loadVersion(c, file)
+	if !ok {
+		return
+	}
+
+	if h.streamContent(c, &version.Content, file.OriginalFilename, version.CreatedAt) {
+		h.auditService.Log(c, "DOWNLOAD", "FILE", &file.ID, fmt.Sprintf("User downloaded version %d of file '%s'", version.Version, file.OriginalFilename))
+	}
+}
+
+// RestoreVersion makes an earlier version current again by copying it to a
+// new version.
+func (h *FileHandler) RestoreVersion(c *gin.Context) {
+	file, _, ok := h.authorizeFile(c, models.PermissionEdit)
+	if !ok {
+		return
+	}
+	version, ok := h.loadVersion(c, file)
+	if !ok {
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	restored, err := h.fileService.RestoreVersion(file, version.Version, userID.(uint))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to restore version: "+err.Error())
+		return
+	}
+
+	h.auditService.Log(c, "RESTORE", "FILE", &file.ID, fmt.Sprintf("User restored version %d of file '%s' as version %d", version.Version, file.OriginalFilename, restored.Version))
+	utils.SuccessResponse(c, "Version restored successfully", gin.H{"file": file, "version": restored})
+}
+
+func (h *FileHandler) loadVersion(c *gin.Context, file *models.File) (*models.FileVersion, bool) {
+	number, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid version number")
+		return nil, false
+	}
+
+	version, err := h.fileService.GetVersion(file.ID, number)
+	if err != nil {
+		if errors.Is(err, services.ErrVersionNotFound) {
+			utils.ErrorResponse(c, http.StatusNotFound, "Version not found")
+			return nil, false
+		}
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve version: "+err.Error())
+		return nil, false
+	}
+	return version, true
+}
@@ -2,11 +2,13 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
 	"filevault-backend/internal/services"
 	"filevault-backend/internal/utils"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -14,7 +16,7 @@ func AuthMiddleware(authService *services.AuthService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var tokenString string
 		authHeader := c.GetHeader("Authorization")
-		
+
 		// Check for token in header first, then in query parameter for downloads
 		if authHeader != "" && strings.HasPrefix(authHeader, "Bearer ") {
 			tokenString = strings.TrimPrefix(authHeader, "Bearer ")
@@ -33,16 +35,43 @@ func AuthMiddleware(authService *services.AuthService) gin.HandlerFunc {
 			return
 		}
 
-		// Set user info in context from the token's claims
-		c.Set("userID", claims.UserID)
-		c.Set("username", claims.Username)
-		c.Set("isAdmin", claims.IsAdmin)
-		
+		// Tokens stay valid until they expire unless their session was terminated.
+		if err := authService.CheckRevoked(claims); err != nil {
+			if errors.Is(err, services.ErrTokenRevoked) {
+				utils.ErrorResponse(c, http.StatusUnauthorized, "Token has been revoked")
+			} else {
+				utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to verify token")
+			}
+			c.Abort()
+			return
+		}
+
+		user, err := authService.GetActiveUser(claims.UserID)
+		if err != nil {
+			if errors.Is(err, services.ErrAccountSuspended) {
+				utils.ErrorResponse(c, http.StatusForbidden, "Account suspended")
+			} else {
+				utils.ErrorResponse(c, http.StatusUnauthorized, "Invalid token")
+			}
+			c.Abort()
+			return
+		}
+
+		// Set user info in context from the current account, so role changes apply immediately
+		c.Set("userID", user.ID)
+		c.Set("username", user.Username)
+		c.Set("isAdmin", user.IsAdmin)
+		c.Set("sessionID", claims.FamilyID)
+		c.Set("twoFactor", user.TOTPEnabled)
+
 		c.Next()
 	}
 }
 
-func AdminMiddleware() gin.HandlerFunc {
+// AdminMiddleware lets admins through. When two-factor authentication is
+// required for admins, those without it can still log in and enable it, but
+// cannot use the admin API until they have.
+func AdminMiddleware(twoFactorService *services.TwoFactorService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		isAdmin, exists := c.Get("isAdmin")
 		if !exists || !isAdmin.(bool) {
@@ -50,6 +79,19 @@ func AdminMiddleware() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
+		if !c.GetBool("twoFactor") {
+			required, err := twoFactorService.AdminsRequired()
+			if err != nil {
+				utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to check two-factor policy")
+				c.Abort()
+				return
+			}
+			if required {
+				utils.ErrorResponse(c, http.StatusForbidden, "Two-factor authentication is required for admin accounts")
+				c.Abort()
+				return
+			}
+		}
 		c.Next()
 	}
-}
\ No newline at end of file
+}
@@ -5,9 +5,10 @@ import (
 	"sync"
 	"time"
 
+	"filevault-backend/internal/utils"
+
 	"github.com/gin-gonic/gin"
 	"golang.org/x/time/rate"
-	"filevault-backend/internal/utils"
 )
 
 type RateLimiter struct {
@@ -48,7 +49,7 @@ func (rl *RateLimiter) Middleware() gin.HandlerFunc {
 	go func() {
 		ticker := time.NewTicker(time.Minute * 5)
 		defer ticker.Stop()
-		
+
 		for range ticker.C {
 			rl.mu.Lock()
 			for key, limiter := range rl.limiters {
@@ -18,4 +18,4 @@ type AuditLog struct {
 
 func (AuditLog) TableName() string {
 	return "audit_logs"
-}
\ No newline at end of file
+}
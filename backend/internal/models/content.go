@@ -1,6 +1,19 @@
 // morningstarl2504/balkanid_repo/BalkanID_repo-f1fc3ed153144eb6d79e3c90f73a0f3d312b9c79/backend/internal/models/content.go
 package models
 
+import "time"
+
+// Malware scan states of a FileContent. Content stored while scanning was
+// disabled stays unscanned; content the scanner could not check, because it
+// refused the stream or every attempt failed, ends up failed.
+const (
+	ScanUnscanned = "unscanned"
+	ScanPending   = "pending"
+	ScanClean     = "clean"
+	ScanInfected  = "infected"
+	ScanFailed    = "failed"
+)
+
 // FileContent represents the actual content of a file, stored once for deduplication.
 type FileContent struct {
 	ID             uint   `json:"-" gorm:"primaryKey"`
@@ -8,8 +21,21 @@ type FileContent struct {
 	FileSize       int64  `json:"file_size" gorm:"not null"`
 	MimeType       string `json:"mime_type" gorm:"not null"`
 	ReferenceCount uint   `json:"-" gorm:"not null;default:1"`
+
+	// Text extracted from documents for full-text search. It is write-only
+	// so listings never load it.
+	ExtractedText string `json:"-" gorm:"type:text;->:false;<-"`
+
+	ScanStatus    string     `json:"scan_status" gorm:"not null;default:unscanned;index"`
+	ScanSignature string     `json:"scan_signature,omitempty"` // Malware found by the scanner
+	ScannedAt     *time.Time `json:"scanned_at,omitempty"`
+
+	// Data key the blob and its renditions are encrypted with, wrapped by the
+	// master key KeyID. Empty for blobs stored in plaintext.
+	KeyID      string `json:"-"`
+	WrappedKey []byte `json:"-"`
 }
 
 func (FileContent) TableName() string {
 	return "file_contents"
-}
\ No newline at end of file
+}
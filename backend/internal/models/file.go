@@ -3,25 +3,42 @@ package models
 
 import (
 	"time"
+
 	"gorm.io/gorm"
 )
 
 type File struct {
 	ID               uint           `json:"id" gorm:"primaryKey"`
-	UserID           uint           `json:"user_id" gorm:"not null;index"`
+	UserID           uint           `json:"user_id" gorm:"not null;index;index:idx_files_user_created,priority:1"`
 	FileContentID    uint           `json:"-" gorm:"not null;index"`
+	FolderID         *uint          `json:"folder_id" gorm:"index"` // nil for the root folder
 	OriginalFilename string         `json:"original_filename" gorm:"not null"`
-	IsPublic         bool           `json:"is_public" gorm:"default:false"`
+	CurrentVersion   int            `json:"current_version" gorm:"not null;default:1"`
 	DownloadCount    int            `json:"download_count" gorm:"default:0"`
-	CreatedAt        time.Time      `json:"created_at"`
+	CreatedAt        time.Time      `json:"created_at" gorm:"index:idx_files_user_created,priority:2"`
 	UpdatedAt        time.Time      `json:"updated_at"`
 	DeletedAt        gorm.DeletedAt `json:"-" gorm:"index"`
 
+	// Set on files the client encrypted before upload. The server only holds
+	// ciphertext; each user's copy of the file key is a FileKey, and Metadata
+	// carries whatever the client needs to decrypt, such as the cipher used.
+	Encrypted          bool   `json:"encrypted" gorm:"not null;default:false"`
+	EncryptionMetadata string `json:"encryption_metadata,omitempty" gorm:"type:text"`
+
+	// Set on full-text search results
+	Rank      float64 `json:"rank,omitempty" gorm:"-"`
+	Highlight string  `json:"highlight,omitempty" gorm:"-"` // Matching excerpt of the document as escaped HTML, matches wrapped in <mark>
+
+	// Set on files listed from the trash
+	TrashedAt *time.Time `json:"trashed_at,omitempty" gorm:"-"`
+	PurgeAt   *time.Time `json:"purge_at,omitempty" gorm:"-"`
+
 	// Relationships
 	User    User        `json:"user" gorm:"foreignKey:UserID"`
 	Content FileContent `json:"content" gorm:"foreignKey:FileContentID"`
+	Tags    []Tag       `json:"tags" gorm:"many2many:file_tags"`
 }
 
 func (File) TableName() string {
 	return "files"
-}
\ No newline at end of file
+}
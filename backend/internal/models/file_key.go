@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// FileKey is the key of a client-encrypted file, wrapped by the client with
+// one user's public key. The owner and every collaborator the file is shared
+// with have their own row; the server stores the wrapped keys but can never
+// unwrap them.
+type FileKey struct {
+	ID         uint      `json:"-" gorm:"primaryKey"`
+	FileID     uint      `json:"file_id" gorm:"not null;uniqueIndex:idx_file_key_user"`
+	UserID     uint      `json:"user_id" gorm:"not null;uniqueIndex:idx_file_key_user;index"`
+	WrappedKey string    `json:"wrapped_key" gorm:"type:text;not null"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func (FileKey) TableName() string {
+	return "file_keys"
+}
+
+// ClientEncryption describes an upload the client encrypted itself.
+type ClientEncryption struct {
+	WrappedKey string // File key wrapped with the uploader's public key; only needed for new files
+	Metadata   string // Opaque parameters the client needs to decrypt, such as the cipher
+}
@@ -2,25 +2,58 @@ package models
 
 import (
 	"time"
+
 	"gorm.io/gorm"
 )
 
+// FileShare grants access to a file. Rows with a Token are public share
+// links; rows with ShareWith grant access to a specific registered user.
 type FileShare struct {
-	ID        uint           `json:"id" gorm:"primaryKey"`
-	FileID    uint           `json:"file_id" gorm:"not null;index"`
-	UserID    uint           `json:"user_id" gorm:"not null;index"`
-	ShareWith *uint          `json:"share_with" gorm:"index"`
-	ShareURL  string         `json:"share_url" gorm:"unique"`
-	ExpiresAt *time.Time     `json:"expires_at"`
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	FileID      uint       `json:"file_id" gorm:"not null;index"`
+	UserID      uint       `json:"user_id" gorm:"not null;index"`
+	ShareWith   *uint      `json:"share_with" gorm:"index"`
+	Permissions Permission `json:"-" gorm:"not null;default:3"` // Granted to ShareWith; links only allow downloads
+	Token       *string    `json:"token,omitempty" gorm:"uniqueIndex;size:64"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+
+	PasswordHash  string `json:"-"`
+	MaxDownloads  *int   `json:"max_downloads"`
+	DownloadCount int    `json:"download_count" gorm:"not null;default:0"`
+
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
 
-	File       File  `json:"file" gorm:"foreignKey:FileID"`
-	User       User  `json:"user" gorm:"foreignKey:UserID"`
-	SharedWith *User `json:"shared_with" gorm:"foreignKey:ShareWith"`
+	// ShareURL is the public download path for link shares; it is not stored.
+	ShareURL    string   `json:"share_url,omitempty" gorm:"-"`
+	HasPassword bool     `json:"has_password" gorm:"-"`
+	Grants      []string `json:"permissions,omitempty" gorm:"-"`
+
+	File       *File `json:"file,omitempty" gorm:"foreignKey:FileID"`
+	User       *User `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	SharedWith *User `json:"shared_with,omitempty" gorm:"foreignKey:ShareWith"`
 }
 
 func (FileShare) TableName() string {
 	return "file_shares"
-}
\ No newline at end of file
+}
+
+// IsExpired reports whether the share has passed its expiry time.
+func (s *FileShare) IsExpired() bool {
+	return s.ExpiresAt != nil && time.Now().After(*s.ExpiresAt)
+}
+
+// IsExhausted reports whether a download-limited share has been used up.
+func (s *FileShare) IsExhausted() bool {
+	return s.MaxDownloads != nil && s.DownloadCount >= *s.MaxDownloads
+}
+
+// AfterFind fills in fields derived from the stored columns.
+func (s *FileShare) AfterFind(tx *gorm.DB) error {
+	s.HasPassword = s.PasswordHash != ""
+	if s.ShareWith != nil {
+		s.Grants = s.Permissions.Names()
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// FileVersion is one entry in a file's history. Every file has at least one;
+// the highest Version is the content the file currently serves.
+type FileVersion struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	FileID        uint      `json:"file_id" gorm:"not null;uniqueIndex:idx_file_version"`
+	Version       int       `json:"version" gorm:"not null;uniqueIndex:idx_file_version"`
+	FileContentID uint      `json:"-" gorm:"not null;index"`
+	UploadedBy    uint      `json:"uploaded_by" gorm:"not null"`
+	RestoredFrom  *int      `json:"restored_from,omitempty"` // Set when the version was created by restoring an older one
+	CreatedAt     time.Time `json:"created_at"`
+
+	Content FileContent `json:"content" gorm:"foreignKey:FileContentID"`
+}
+
+func (FileVersion) TableName() string {
+	return "file_versions"
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Folder groups a user's files. Folders nest through ParentID; a nil parent
+// is the user's root.
+type Folder struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`
+	UserID    uint           `json:"user_id" gorm:"not null;index"`
+	ParentID  *uint          `json:"parent_id" gorm:"index"`
+	Name      string         `json:"name" gorm:"not null;size:255"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+
+	// Path is the slash-separated location of the folder, filled in when
+	// the folder is looked up on its own.
+	Path string `json:"path,omitempty" gorm:"-"`
+}
+
+func (Folder) TableName() string {
+	return "folders"
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Job states. A failed attempt puts a job back to pending with a later RunAt
+// until it runs out of attempts and is marked dead.
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobDead      = "dead"
+)
+
+// Job is a unit of background work, such as processing newly stored content.
+// Jobs live in Postgres so they survive restarts and can be claimed by any
+// number of workers.
+type Job struct {
+	ID          uint            `json:"id" gorm:"primaryKey"`
+	Type        string          `json:"type" gorm:"not null;index"`
+	Payload     json.RawMessage `json:"payload" gorm:"type:jsonb;not null"`
+	Status      string          `json:"status" gorm:"not null;default:pending;index:idx_jobs_status_run_at"`
+	Attempts    int             `json:"attempts" gorm:"not null;default:0"`
+	MaxAttempts int             `json:"max_attempts" gorm:"not null"`
+	RunAt       time.Time       `json:"run_at" gorm:"not null;index:idx_jobs_status_run_at"` // Earliest time the next attempt may start
+	LockedAt    *time.Time      `json:"locked_at"`                                           // When the current attempt started
+	LastError   string          `json:"last_error" gorm:"type:text"`
+	CompletedAt *time.Time      `json:"completed_at"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+func (Job) TableName() string {
+	return "jobs"
+}
+
+// JobStats counts jobs per status.
+type JobStats struct {
+	Pending   int64 `json:"pending"`
+	Running   int64 `json:"running"`
+	Succeeded int64 `json:"succeeded"`
+	Dead      int64 `json:"dead"`
+}
@@ -0,0 +1,71 @@
+package models
+
+import "sort"
+
+// Permission is a single action a user may perform on a file.
+type Permission uint
+
+const (
+	PermissionView Permission = 1 << iota
+	PermissionDownload
+	PermissionEdit
+	PermissionReshare
+	PermissionDelete
+)
+
+// PermissionAll is held by a file's owner and by admins.
+const PermissionAll = PermissionView | PermissionDownload | PermissionEdit | PermissionReshare | PermissionDelete
+
+var permissionNames = map[Permission]string{
+	PermissionView:     "view",
+	PermissionDownload: "download",
+	PermissionEdit:     "edit",
+	PermissionReshare:  "reshare",
+	PermissionDelete:   "delete",
+}
+
+// Share roles are named presets of permissions.
+var ShareRoles = map[string]Permission{
+	"viewer": PermissionView | PermissionDownload,
+	"editor": PermissionView | PermissionDownload | PermissionEdit,
+}
+
+// Has reports whether every permission in want is present.
+func (p Permission) Has(want Permission) bool {
+	return p&want == want
+}
+
+// Names lists the individual permissions in p, sorted by name.
+func (p Permission) Names() []string {
+	names := []string{}
+	for perm, name := range permissionNames {
+		if p.Has(perm) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParsePermissions converts permission names into a Permission set. ok is
+// false when a name is not recognised.
+func ParsePermissions(names []string) (perms Permission, ok bool) {
+	for _, name := range names {
+		found := false
+		for perm, permName := range permissionNames {
+			if permName == name {
+				perms |= perm
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, false
+		}
+	}
+	return perms, true
+}
+
+func (p Permission) String() string {
+	return permissionNames[p]
+}
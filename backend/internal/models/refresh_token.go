@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// RefreshToken is one link in a chain of refresh tokens. Every login starts a
+// new family, and each refresh rotates the presented token for a new one in
+// the same family. A family is the Session with the ID FamilyID, and is
+// revoked by deleting the session. Only a SHA-256 hash of the token is stored.
+type RefreshToken struct {
+	ID        uint       `json:"-" gorm:"primaryKey"`
+	UserID    uint       `json:"-" gorm:"not null;index"`
+	FamilyID  string     `json:"-" gorm:"not null;size:36;index"`
+	TokenHash string     `json:"-" gorm:"not null;size:64;uniqueIndex"`
+	ExpiresAt time.Time  `json:"-" gorm:"not null;index"`
+	RotatedAt *time.Time `json:"-"` // Set once the token has been exchanged
+	CreatedAt time.Time  `json:"-"`
+}
+
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
@@ -1,6 +1,8 @@
 // morningstarl2504/balkanid_repo/BalkanID_repo-f1fc3ed153144eb6d79e3c90f73a0f3d312b9c79/backend/internal/models/response.go
 package models
 
+import "time"
+
 type Response struct {
 	Success bool        `json:"success"`
 	Message string      `json:"message"`
@@ -20,8 +22,102 @@ type RegisterRequest struct {
 }
 
 type LoginResponse struct {
-	Token string `json:"token"`
-	User  User   `json:"user"`
+	Token        string    `json:"token"` // Short-lived access token
+	ExpiresAt    time.Time `json:"expires_at"`
+	RefreshToken string    `json:"refresh_token"`
+	User         User      `json:"user"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// TwoFactorChallengeResponse is returned by a login instead of tokens when
+// the account has two-factor authentication.
+type TwoFactorChallengeResponse struct {
+	TwoFactorRequired bool      `json:"two_factor_required"`
+	Challenge         string    `json:"challenge"`
+	ExpiresAt         time.Time `json:"expires_at"`
+}
+
+type TwoFactorLoginRequest struct {
+	Challenge string `json:"challenge" binding:"required"`
+	Code      string `json:"code" binding:"required,max=32"` // TOTP or recovery code
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required,max=32"`
+}
+
+// Setting up two-factor authentication takes the password as well, so a
+// stolen access token alone cannot bind the account to someone else's
+// authenticator.
+type TwoFactorEnrollRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+type TwoFactorConfirmRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required,max=32"`
+}
+
+type TwoFactorPolicyRequest struct {
+	RequireForAdmins *bool `json:"require_for_admins" binding:"required"`
+}
+
+type UpdateQuotaRequest struct {
+	StorageQuota *int64 `json:"storage_quota" binding:"omitempty,min=-1"` // -1 for unlimited, null for the default
+}
+
+type UpdateRoleRequest struct {
+	IsAdmin *bool `json:"is_admin" binding:"required"`
+}
+
+type CreateShareRequest struct {
+	ExpiresAt      *time.Time `json:"expires_at"`
+	ExpiresInHours int        `json:"expires_in_hours" binding:"omitempty,min=1"`
+	Password       string     `json:"password" binding:"omitempty,min=4"`
+	MaxDownloads   *int       `json:"max_downloads" binding:"omitempty,min=1"`
+}
+
+type ShareWithUserRequest struct {
+	User        string     `json:"user" binding:"required"` // Username or email of the recipient
+	Role        string     `json:"role" binding:"omitempty,oneof=viewer editor"`
+	Permissions []string   `json:"permissions"` // Explicit permissions, instead of a role
+	ExpiresAt   *time.Time `json:"expires_at"`
+	WrappedKey  string     `json:"wrapped_key" binding:"max=65536"` // Key of an encrypted file, wrapped for the recipient
+}
+
+type UpdatePublicKeyRequest struct {
+	PublicKey string `json:"public_key" binding:"required,max=65536"`
+	Algorithm string `json:"algorithm" binding:"required,max=64"` // How clients wrap keys with it, e.g. "RSA-OAEP-256"
+}
+
+type UpdateFileKeyRequest struct {
+	WrappedKey string `json:"wrapped_key" binding:"required,max=65536"`
+}
+
+type UpdateFileRequest struct {
+	OriginalFilename *string `json:"original_filename" binding:"omitempty,min=1,max=255"`
+	FolderID         *uint   `json:"folder_id"` // 0 moves the file to the root folder
+}
+
+type TagFileRequest struct {
+	Tags []string `json:"tags" binding:"required,min=1,dive,required,max=64"`
+}
+
+type RenameTagRequest struct {
+	Name string `json:"name" binding:"required,max=64"`
+}
+
+type CreateFolderRequest struct {
+	Name     string `json:"name" binding:"required,max=255"`
+	ParentID *uint  `json:"parent_id"`
+}
+
+type UpdateFolderRequest struct {
+	Name     *string `json:"name" binding:"omitempty,min=1,max=255"`
+	ParentID *uint   `json:"parent_id"` // 0 moves the folder to the root
 }
 
 type StorageStats struct {
@@ -30,17 +126,32 @@ type StorageStats struct {
 	SavingsBytes      int64   `json:"storage_savings_bytes"`
 	SavingsPercentage float64 `json:"storage_savings_percentage"`
 	Quota             int64   `json:"user_quota"`
+	QuotaUsed         int64   `json:"quota_used"`
+	QuotaAccounting   string  `json:"quota_accounting"`
 }
 
 type SearchFilters struct {
-	Filename     string   `form:"filename"`
-	MimeType     string   `form:"mime_type"`
-	MinSize      int64    `form:"min_size"`
-	MaxSize      int64    `form:"max_size"`
-	StartDate    string   `form:"start_date"`
-	EndDate      string   `form:"end_date"`
-	Tags         []string `form:"tags"`
-	UploaderName string   `form:"uploader_name"`
-	Page         int      `form:"page" binding:"min=1"`
-	Limit        int      `form:"limit" binding:"min=1,max=100"`
-}
\ No newline at end of file
+	Query     string   `form:"q"` // Full-text search over names, tags and document text
+	Filename  string   `form:"filename"`
+	MimeType  string   `form:"mime_type"`
+	MinSize   int64    `form:"min_size"`
+	MaxSize   int64    `form:"max_size"`
+	StartDate string   `form:"start_date"`
+	EndDate   string   `form:"end_date"`
+	Tags      []string `form:"tags"`
+	TagMatch  string   `form:"tag_match" binding:"omitempty,oneof=any all"` // Files with any (default) or all of Tags
+	FolderID  *uint    `form:"folder_id"`                                   // 0 lists the root folder
+	Path      string   `form:"path"`                                        // Folder path, instead of folder_id
+	Sort      string   `form:"sort" binding:"omitempty,oneof=relevance name size created_at download_count"`
+	Order     string   `form:"order" binding:"omitempty,oneof=asc desc"`
+	Cursor    string   `form:"cursor"` // From next_cursor; takes the place of page
+	Page      int      `form:"page" binding:"omitempty,min=1"`
+	Limit     int      `form:"limit" binding:"omitempty,min=1,max=100"`
+}
+
+type JobFilters struct {
+	Status string `form:"status" binding:"omitempty,oneof=pending running succeeded dead"`
+	Type   string `form:"type"`
+	Page   int    `form:"page" binding:"omitempty,min=1"`
+	Limit  int    `form:"limit" binding:"omitempty,min=1,max=100"`
+}
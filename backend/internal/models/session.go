@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Session is one login of a user, on one device. Its ID is the family shared
+// by the refresh tokens and access tokens issued to that login, so deleting
+// the session logs the device out.
+type Session struct {
+	ID         string    `json:"id" gorm:"primaryKey;size:36"`
+	UserID     uint      `json:"user_id" gorm:"not null;index"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address" gorm:"size:45"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at" gorm:"not null;index"`
+
+	// Set when listing the sessions of the user making the request
+	Current bool `json:"current" gorm:"-"`
+}
+
+func (Session) TableName() string {
+	return "sessions"
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Tag is a user-defined label. Tags belong to the owner of the files they are
+// attached to, and names are unique per user, compared case-insensitively.
+type Tag struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"-" gorm:"not null;uniqueIndex:idx_user_tag"`
+	Name      string    `json:"name" gorm:"not null;size:64;uniqueIndex:idx_user_tag"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Number of files carrying the tag, filled in when listing tags
+	FileCount int64 `json:"file_count" gorm:"-"`
+}
+
+func (Tag) TableName() string {
+	return "tags"
+}
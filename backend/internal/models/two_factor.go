@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// RecoveryCode is a one-time code that stands in for a TOTP code when the
+// user has lost their authenticator. Only a SHA-256 hash of it is stored.
+type RecoveryCode struct {
+	ID        uint       `json:"-" gorm:"primaryKey"`
+	UserID    uint       `json:"-" gorm:"not null;index"`
+	CodeHash  string     `json:"-" gorm:"not null;size:64"`
+	UsedAt    *time.Time `json:"-"`
+	CreatedAt time.Time  `json:"-"`
+}
+
+func (RecoveryCode) TableName() string {
+	return "recovery_codes"
+}
+
+// TwoFactorChallenge is handed out by a login with a correct password for an
+// account with two-factor authentication, and is exchanged for tokens
+// together with a code. Only a SHA-256 hash of the challenge is stored.
+type TwoFactorChallenge struct {
+	TokenHash string    `gorm:"primaryKey;size:64"`
+	UserID    uint      `gorm:"not null;index"`
+	Attempts  int       `gorm:"not null;default:0"` // Wrong codes entered so far
+	ExpiresAt time.Time `gorm:"not null;index"`
+	CreatedAt time.Time
+}
+
+func (TwoFactorChallenge) TableName() string {
+	return "two_factor_challenges"
+}
+
+// Setting is a server-wide option admins can change at runtime.
+type Setting struct {
+	Key       string `gorm:"primaryKey;size:64"`
+	Value     string `gorm:"not null"`
+	UpdatedAt time.Time
+}
+
+func (Setting) TableName() string {
+	return "settings"
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// UploadSession tracks a resumable (tus) upload until all bytes have arrived.
+type UploadSession struct {
+	ID         string    `json:"id" gorm:"primaryKey;size:36"`
+	UserID     uint      `json:"user_id" gorm:"not null;index"`
+	Filename   string    `json:"filename" gorm:"not null"`
+	MimeType   string    `json:"mime_type"`
+	Length     int64     `json:"length" gorm:"not null"`
+	Offset     int64     `json:"offset" gorm:"column:upload_offset;not null;default:0"`
+	Metadata   string    `json:"metadata" gorm:"type:text"`
+	FolderID   *uint     `json:"folder_id"`
+	FileID     *uint     `json:"file_id"`
+	KeyID      string    `json:"-"` // Master key wrapping WrappedKey
+	WrappedKey []byte    `json:"-"` // Data key the chunks are encrypted with, if any
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+
+	// Set for data encrypted by the client, from the Upload-Metadata
+	Encrypted        bool   `json:"encrypted"`
+	ClientWrappedKey string `json:"-" gorm:"type:text"`
+	ClientMetadata   string `json:"-" gorm:"type:text"`
+
+	// Unfinished uploads are discarded after this time, which every chunk
+	// received pushes back
+	ExpiresAt *time.Time `json:"expires_at" gorm:"index"`
+}
+
+func (UploadSession) TableName() string {
+	return "upload_sessions"
+}
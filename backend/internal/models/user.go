@@ -3,6 +3,7 @@ package models
 
 import (
 	"time"
+
 	"gorm.io/gorm"
 )
 
@@ -12,15 +13,27 @@ type User struct {
 	Email        string         `json:"email" gorm:"unique;not null"`
 	PasswordHash string         `json:"-" gorm:"not null"`
 	IsAdmin      bool           `json:"is_admin" gorm:"default:false"`
-	StorageQuota int64          `json:"storage_quota" gorm:"default:10485760"` // Default 10MB
+	StorageQuota *int64         `json:"storage_quota"` // Bytes, -1 for unlimited; unset uses the configured default
+	IsSuspended  bool           `json:"is_suspended" gorm:"default:false"`
 	CreatedAt    time.Time      `json:"created_at"`
 	UpdatedAt    time.Time      `json:"updated_at"`
 	DeletedAt    gorm.DeletedAt `json:"-" gorm:"index"`
 
+	// Key clients wrap file keys with when sharing encrypted files with the
+	// user. The matching private key never leaves the user's devices.
+	PublicKey          string `json:"public_key,omitempty" gorm:"type:text"`
+	PublicKeyAlgorithm string `json:"public_key_algorithm,omitempty"`
+
+	// TOTP two-factor authentication. The secret is set on enrollment and
+	// only takes effect once a code generated from it has been confirmed.
+	TOTPSecret   string `json:"-"`
+	TOTPEnabled  bool   `json:"totp_enabled" gorm:"default:false"`
+	TOTPLastStep int64  `json:"-"` // Time step of the last accepted code, so a code cannot be replayed
+
 	// Relationships
 	Files []File `json:"files" gorm:"foreignKey:UserID"`
 }
 
 func (User) TableName() string {
 	return "users"
-}
\ No newline at end of file
+}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"time"
+
+	"filevault-backend/internal/database"
+	"filevault-backend/internal/models"
+)
+
+// AccessService is the single place that decides what a user may do with a
+// file. Owners and admins hold every permission; everyone else holds the
+// union of the permissions granted to them through active direct shares.
+type AccessService struct{}
+
+func NewAccessService() *AccessService {
+	return &AccessService{}
+}
+
+func (s *AccessService) Permissions(file *models.File, userID uint, isAdmin bool) models.Permission {
+	if file.UserID == userID || isAdmin {
+		return models.PermissionAll
+	}
+
+	var grants []models.Permission
+	database.DB.Model(&models.FileShare{}).
+		Where("file_id = ? AND share_with = ?", file.ID, userID).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Pluck("permissions", &grants)
+
+	var perms models.Permission
+	for _, grant := range grants {
+		perms |= grant
+	}
+	return perms
+}
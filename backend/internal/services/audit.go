@@ -4,6 +4,7 @@ package services
 import (
 	"filevault-backend/internal/database"
 	"filevault-backend/internal/models"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -31,4 +32,4 @@ func (s *AuditService) Log(c *gin.Context, action string, resource string, resou
 	}
 
 	database.DB.Create(&log)
-}
\ No newline at end of file
+}
@@ -13,19 +13,28 @@ import (
 )
 
 type AuthService struct {
-	jwtSecret string
+	jwtSecret      string
+	defaultQuota   int64
+	accessTTL      time.Duration
+	refreshTTL     time.Duration
+	sessionService *SessionService
 }
 
 type Claims struct {
 	UserID   uint   `json:"user_id"`
 	Username string `json:"username"`
 	IsAdmin  bool   `json:"is_admin"`
+	FamilyID string `json:"fid"` // Session, and refresh token family, the token was issued to
 	jwt.RegisteredClaims
 }
 
-func NewAuthService(jwtSecret string) *AuthService {
+func NewAuthService(jwtSecret string, defaultQuota int64, accessTTL, refreshTTL time.Duration, sessionService *SessionService) *AuthService {
 	return &AuthService{
-		jwtSecret: jwtSecret,
+		jwtSecret:      jwtSecret,
+		defaultQuota:   defaultQuota,
+		accessTTL:      accessTTL,
+		refreshTTL:     refreshTTL,
+		sessionService: sessionService,
 	}
 }
 
@@ -38,19 +47,25 @@ func (s *AuthService) CheckPassword(password, hash string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 }
 
-func (s *AuthService) GenerateToken(user *models.User) (string, error) {
+// GenerateToken issues a short-lived access token tied to a session, so
+// terminating the session also invalidates its access tokens.
+func (s *AuthService) GenerateToken(user *models.User, familyID string) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(s.accessTTL)
 	claims := &Claims{
 		UserID:   user.ID,
 		Username: user.Username,
 		IsAdmin:  user.IsAdmin,
+		FamilyID: familyID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(s.jwtSecret))
+	signed, err := token.SignedString([]byte(s.jwtSecret))
+	return signed, expiresAt, err
 }
 
 func (s *AuthService) ValidateToken(tokenString string) (*Claims, error) {
@@ -70,6 +85,21 @@ func (s *AuthService) ValidateToken(tokenString string) (*Claims, error) {
 	return claims, nil
 }
 
+var ErrAccountSuspended = errors.New("account suspended")
+
+// GetActiveUser loads the account behind a token, rejecting deleted and
+// suspended users so their existing tokens stop working immediately.
+func (s *AuthService) GetActiveUser(userID uint) (*models.User, error) {
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	if user.IsSuspended {
+		return nil, ErrAccountSuspended
+	}
+	return &user, nil
+}
+
 func (s *AuthService) Register(req *models.RegisterRequest) (*models.User, error) {
 	// Check if user exists
 	var existingUser models.User
@@ -84,12 +114,11 @@ func (s *AuthService) Register(req *models.RegisterRequest) (*models.User, error
 	}
 
 	// Create user
-	// Fixed code:
 	user := &models.User{
-    	Username:     req.Username,
-    	Email:        req.Email,
-    	PasswordHash: hashedPassword,
-    	IsAdmin:      false,
+		Username:     req.Username,
+		Email:        req.Email,
+		PasswordHash: hashedPassword,
+		IsAdmin:      false,
 	}
 
 	if err := database.DB.Create(user).Error; err != nil {
@@ -112,5 +141,42 @@ func (s *AuthService) Login(req *models.LoginRequest) (*models.User, error) {
 		return nil, errors.New("invalid credentials")
 	}
 
+	if user.IsSuspended {
+		return nil, ErrAccountSuspended
+	}
+
+	return &user, nil
+}
+
+// SetPublicKey publishes the public key others wrap file keys with when
+// sharing encrypted files with the user. Keys already wrapped with an older
+// public key are not touched; the client has to rewrap them.
+func (s *AuthService) SetPublicKey(userID uint, publicKey, algorithm string) (*models.User, error) {
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	if err := database.DB.Model(&user).Updates(map[string]interface{}{
+		"public_key":           publicKey,
+		"public_key_algorithm": algorithm,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// FindPublicKey looks up the public key of a user by username or email.
+func (s *AuthService) FindPublicKey(identifier string) (*models.User, error) {
+	var user models.User
+	err := database.DB.Where("username = ? OR email = ?", identifier, identifier).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.PublicKey == "" {
+		return nil, ErrNoPublicKey
+	}
 	return &user, nil
 }
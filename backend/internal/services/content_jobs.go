@@ -0,0 +1,230 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"filevault-backend/internal/database"
+	"filevault-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Background jobs run by FileService.
+const (
+	JobScanContent        = "scan_content"        // Check new content for malware
+	JobIndexContent       = "index_content"       // Extract searchable text from a document
+	JobGenerateThumbnails = "generate_thumbnails" // Render an image in every thumbnail size
+	JobDeleteBlob         = "delete_blob"         // Delete a blob whose content row is gone
+)
+
+// QuarantinePrefix marks blobs moved aside because malware was found in them.
+// Quarantined blobs are kept for inspection until their content is deleted.
+const QuarantinePrefix = "quarantine-"
+
+type contentJob struct {
+	ContentID uint `json:"content_id"`
+}
+
+type blobJob struct {
+	Key string `json:"key"`
+}
+
+func (s *FileService) registerJobs() {
+	if s.scanner != nil {
+		s.jobService.Register(JobScanContent, s.runScanContent)
+		s.jobService.OnDead(JobScanContent, s.scanFailed)
+	}
+	s.jobService.Register(JobIndexContent, s.runIndexContent)
+	s.jobService.Register(JobGenerateThumbnails, s.runGenerateThumbnails)
+	s.jobService.Register(JobDeleteBlob, s.runDeleteBlob)
+}
+
+// enqueueContentJobs schedules the processing of newly stored content within
+// the transaction that creates it, so content never exists without the jobs
+// that scan, index and thumbnail it.
+func (s *FileService) enqueueContentJobs(tx *gorm.DB, content *models.FileContent) error {
+	job := contentJob{ContentID: content.ID}
+	if content.ScanStatus == models.ScanPending {
+		if _, err := s.jobService.EnqueueTx(tx, JobScanContent, job); err != nil {
+			return err
+		}
+	}
+	if canExtractText(content.MimeType) {
+		if _, err := s.jobService.EnqueueTx(tx, JobIndexContent, job); err != nil {
+			return err
+		}
+	}
+	if canThumbnail(content.MimeType) {
+		if _, err := s.jobService.EnqueueTx(tx, JobGenerateThumbnails, job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadJobContent returns the content a job refers to, or nil if it was
+// deleted before the job ran.
+func loadJobContent(payload json.RawMessage) (*models.FileContent, error) {
+	var job contentJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return nil, permanent(err)
+	}
+	var content models.FileContent
+	if err := database.DB.First(&content, job.ContentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &content, nil
+}
+
+// runScanContent checks content for malware and quarantines it if any is
+// found.
+func (s *FileService) runScanContent(ctx context.Context, payload json.RawMessage) error {
+	content, err := loadJobContent(payload)
+	if content == nil {
+		return err
+	}
+	switch content.ScanStatus {
+	case models.ScanPending, models.ScanFailed:
+		// Failed content is scanned again when its dead job is retried.
+	case models.ScanInfected:
+		// An earlier attempt found malware but did not finish quarantining it.
+		return s.quarantine(content)
+	default:
+		return nil
+	}
+
+	if max := s.scanner.MaxSize(); max > 0 && content.FileSize > max {
+		return permanent(ErrScanTooLarge)
+	}
+	reader, err := s.OpenContent(content)
+	if err != nil {
+		return err
+	}
+	result, err := s.scanner.Scan(ctx, reader)
+	reader.Close()
+	if err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{"scan_status": models.ScanClean, "scanned_at": time.Now()}
+	if result.Infected {
+		updates["scan_status"] = models.ScanInfected
+		updates["scan_signature"] = result.Signature
+	}
+	// The status is recorded before the blob is moved, so downloads are
+	// refused from this point on.
+	if err := database.DB.Model(content).Updates(updates).Error; err != nil {
+		return err
+	}
+	if !result.Infected {
+		return nil
+	}
+	log.Printf("Malware %q found in content %s", result.Signature, content.SHA256Hash)
+	return s.quarantine(content)
+}
+
+// scanFailed records that content could not be scanned once its scan job is
+// dead, so downloads follow the scan failure policy instead of waiting for a
+// scan that is not coming.
+func (s *FileService) scanFailed(payload json.RawMessage, lastErr string) {
+	var job contentJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return
+	}
+	err := database.DB.Model(&models.FileContent{}).
+		Where("id = ? AND scan_status = ?", job.ContentID, models.ScanPending).
+		Updates(map[string]interface{}{"scan_status": models.ScanFailed, "scanned_at": time.Now()}).Error
+	if err != nil {
+		log.Printf("Recording failed malware scan of content %d failed: %v", job.ContentID, err)
+		return
+	}
+	log.Printf("Content %d could not be scanned for malware: %s", job.ContentID, lastErr)
+}
+
+// ScanFailureBlocks reports whether content that could not be scanned is
+// kept from being downloaded.
+func (s *FileService) ScanFailureBlocks() bool {
+	return s.scanFailurePolicy != ScanFailureAllow
+}
+
+// quarantine moves an infected blob out of the way of downloads and drops
+// its renditions.
+func (s *FileService) quarantine(content *models.FileContent) error {
+	err := s.storageService.Move(content.SHA256Hash, QuarantinePrefix+content.SHA256Hash)
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return err
+	}
+	for _, key := range thumbnailKeys(content.SHA256Hash) {
+		if err := s.storageService.Delete(key); err != nil && !errors.Is(err, ErrObjectNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// runIndexContent extracts searchable text from a stored document.
+func (s *FileService) runIndexContent(_ context.Context, payload json.RawMessage) error {
+	content, err := loadJobContent(payload)
+	if content == nil || content.ScanStatus == models.ScanInfected {
+		return err
+	}
+
+	reader, err := s.OpenContent(content)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	text, err := extractText(reader, content.FileSize, content.MimeType)
+	if err != nil {
+		// The document is unreadable; trying again will not change that.
+		return permanent(err)
+	}
+	return database.DB.Model(content).Update("extracted_text", text).Error
+}
+
+func (s *FileService) runGenerateThumbnails(_ context.Context, payload json.RawMessage) error {
+	content, err := loadJobContent(payload)
+	if content == nil || content.ScanStatus == models.ScanInfected {
+		return err
+	}
+	return s.generateThumbnails(content)
+}
+
+// runDeleteBlob deletes the blob, and renditions, of a content row that has
+// been deleted.
+func (s *FileService) runDeleteBlob(_ context.Context, payload json.RawMessage) error {
+	var job blobJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return permanent(err)
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		// The same content may have been uploaded again since; the hash lock
+		// keeps it from being promoted while the blob is deleted.
+		if err := lockContentHash(tx, job.Key); err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&models.FileContent{}).Where("sha256_hash = ?", job.Key).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		return s.deleteBlob(job.Key)
+	})
+}
+
+// lockContentHash serializes, until tx ends, everything that stores or
+// deletes the blob of a content hash.
+func lockContentHash(tx *gorm.DB, hash string) error {
+	return tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", hash).Error
+}
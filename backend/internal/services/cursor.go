@@ -0,0 +1,80 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"filevault-backend/internal/models"
+)
+
+var ErrInvalidCursor = errors.New("invalid or expired cursor")
+
+// fileSortColumns maps the sort keys accepted by file listings to columns.
+var fileSortColumns = map[string]string{
+	"name":           "files.original_filename",
+	"size":           "file_contents.file_size",
+	"created_at":     "files.created_at",
+	"download_count": "files.download_count",
+	"relevance":      "search_rank",
+}
+
+// fileCursor marks the last file of a page: the value it was sorted by and
+// its ID, which breaks ties. It is opaque to clients.
+type fileCursor struct {
+	Sort  string          `json:"s"`
+	Order string          `json:"o"`
+	Value json.RawMessage `json:"v"`
+	ID    uint            `json:"id"`
+}
+
+func encodeFileCursor(file *models.File, sort, order string) string {
+	var value interface{}
+	switch sort {
+	case "name":
+		value = file.OriginalFilename
+	case "size":
+		value = file.Content.FileSize
+	case "download_count":
+		value = file.DownloadCount
+	default:
+		value = file.CreatedAt
+	}
+	raw, _ := json.Marshal(value)
+	encoded, _ := json.Marshal(fileCursor{Sort: sort, Order: order, Value: raw, ID: file.ID})
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// decodeFileCursor returns the sort value and ID stored in a cursor. A cursor
+// only applies to the sort and order it was issued for.
+func decodeFileCursor(encoded, sort, order string) (interface{}, uint, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, 0, ErrInvalidCursor
+	}
+	var cursor fileCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil || cursor.Sort != sort || cursor.Order != order {
+		return nil, 0, ErrInvalidCursor
+	}
+
+	var value interface{}
+	switch sort {
+	case "name":
+		var name string
+		err = json.Unmarshal(cursor.Value, &name)
+		value = name
+	case "size", "download_count":
+		var n int64
+		err = json.Unmarshal(cursor.Value, &n)
+		value = n
+	default:
+		var t time.Time
+		err = json.Unmarshal(cursor.Value, &t)
+		value = t
+	}
+	if err != nil {
+		return nil, 0, ErrInvalidCursor
+	}
+	return value, cursor.ID, nil
+}
@@ -0,0 +1,245 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Encrypted blobs are a short header followed by the data sealed with
+// AES-256-GCM in fixed-size segments, so a download can seek to any offset
+// and only decrypt the segments it reads. Each segment's nonce is the
+// header's random prefix followed by the segment number, and the last
+// segment is sealed with a different additional-data byte, so segments cannot
+// be reordered, dropped or cut off without detection. The last segment is
+// always shorter than blobSegmentSize, and may be empty.
+//
+// Header: "FVE1" | 8-byte nonce prefix
+const (
+	blobMagic       = "FVE1"
+	blobHeaderSize  = len(blobMagic) + 8
+	blobSegmentSize = 64 << 10
+	blobTagSize     = 16
+)
+
+var ErrBlobCorrupt = errors.New("encrypted blob is corrupt or was encrypted with a different key")
+
+func newBlobCipher(dataKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func segmentNonce(prefix []byte, index int64) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[8:], uint32(index))
+	return nonce
+}
+
+func segmentAD(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// putBlob stores r under key, encrypted with dataKey unless it is nil, and
+// returns the number of plaintext bytes read.
+func putBlob(storage StorageService, key string, r io.Reader, dataKey []byte) (int64, error) {
+	if dataKey == nil {
+		return storage.Put(key, r)
+	}
+	encrypter, err := newEncryptReader(r, dataKey)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := storage.Put(key, encrypter); err != nil {
+		return 0, err
+	}
+	return encrypter.n, nil
+}
+
+// openBlob opens the object under key, decrypting it with dataKey unless it
+// is nil.
+func openBlob(storage StorageService, key string, dataKey []byte) (io.ReadSeekCloser, error) {
+	reader, err := storage.Open(key)
+	if err != nil || dataKey == nil {
+		return reader, err
+	}
+	decrypter, err := newDecryptReader(reader, dataKey)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	return decrypter, nil
+}
+
+// encryptReader encrypts a stream as it is read.
+type encryptReader struct {
+	src    io.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	index  int64
+	plain  []byte
+	out    []byte // Sealed output not yet returned
+	done   bool
+	n      int64 // Plaintext bytes read from src
+}
+
+func newEncryptReader(src io.Reader, dataKey []byte) (*encryptReader, error) {
+	aead, err := newBlobCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, 8)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	return &encryptReader{
+		src:    src,
+		aead:   aead,
+		prefix: prefix,
+		plain:  make([]byte, blobSegmentSize),
+		out:    append([]byte(blobMagic), prefix...),
+	}, nil
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(r.src, r.plain)
+		r.n += int64(n)
+		final := false
+		switch {
+		case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+			final = true
+		case err != nil:
+			return 0, err
+		}
+		r.out = r.aead.Seal(r.out[:0], segmentNonce(r.prefix, r.index), r.plain[:n], segmentAD(final))
+		r.index++
+		r.done = final
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// decryptReader gives seekable access to the plaintext of an encrypted blob.
+type decryptReader struct {
+	src      io.ReadSeekCloser
+	aead     cipher.AEAD
+	prefix   []byte
+	size     int64 // Plaintext size
+	last     int64 // Index of the final segment
+	offset   int64
+	index    int64 // Index of the segment in plain, or -1
+	plain    []byte
+	sealed   []byte
+	lastSize int64 // Sealed size of the final segment
+}
+
+func newDecryptReader(src io.ReadSeekCloser, dataKey []byte) (*decryptReader, error) {
+	aead, err := newBlobCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, blobHeaderSize)
+	if _, err := io.ReadFull(src, header); err != nil || string(header[:len(blobMagic)]) != blobMagic {
+		return nil, ErrBlobCorrupt
+	}
+	total, err := src.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	body := total - int64(blobHeaderSize)
+	sealedSegment := int64(blobSegmentSize + blobTagSize)
+	last, lastSize := body/sealedSegment, body%sealedSegment
+	if lastSize < blobTagSize {
+		return nil, ErrBlobCorrupt
+	}
+	return &decryptReader{
+		src:      src,
+		aead:     aead,
+		prefix:   header[len(blobMagic):],
+		size:     last*blobSegmentSize + lastSize - blobTagSize,
+		last:     last,
+		index:    -1,
+		sealed:   make([]byte, sealedSegment),
+		lastSize: lastSize,
+	}, nil
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		// EOF is only trusted once the final segment has been authenticated,
+		// which also covers an empty one.
+		if r.index != r.last {
+			if err := r.load(r.last); err != nil {
+				return 0, err
+			}
+		}
+		return 0, io.EOF
+	}
+	index := r.offset / blobSegmentSize
+	if index != r.index {
+		if err := r.load(index); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain[r.offset-index*blobSegmentSize:])
+	r.offset += int64(n)
+	return n, nil
+}
+
+func (r *decryptReader) load(index int64) error {
+	sealedSegment := int64(blobSegmentSize + blobTagSize)
+	if _, err := r.src.Seek(int64(blobHeaderSize)+index*sealedSegment, io.SeekStart); err != nil {
+		return err
+	}
+	sealed := r.sealed
+	if index == r.last {
+		sealed = sealed[:r.lastSize]
+	}
+	if _, err := io.ReadFull(r.src, sealed); err != nil {
+		return fmt.Errorf("reading encrypted blob: %w", err)
+	}
+	plain, err := r.aead.Open(r.plain[:0], segmentNonce(r.prefix, index), sealed, segmentAD(index == r.last))
+	if err != nil {
+		r.index = -1
+		return ErrBlobCorrupt
+	}
+	r.plain, r.index = plain, index
+	return nil
+}
+
+func (r *decryptReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *decryptReader) Close() error {
+	return r.src.Close()
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// blobSizes are plaintext sizes around the segment boundaries.
+var blobSizes = []int{
+	0, 1,
+	blobSegmentSize - 1, blobSegmentSize, blobSegmentSize + 1,
+	2*blobSegmentSize - 1, 2 * blobSegmentSize, 2*blobSegmentSize + 17,
+}
+
+func testDataKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func testPlaintext(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i*7 + i/blobSegmentSize)
+	}
+	return data
+}
+
+// putTestBlob encrypts data into a fresh memory store and returns the store.
+func putTestBlob(t *testing.T, data, dataKey []byte) *MemoryStorage {
+	t.Helper()
+	storage := NewMemoryStorage()
+	n, err := putBlob(storage, "blob", bytes.NewReader(data), dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)) {
+		t.Fatalf("putBlob read %d bytes, want %d", n, len(data))
+	}
+	return storage
+}
+
+// sealedBlob returns the raw bytes stored under key.
+func sealedBlob(t *testing.T, storage StorageService, key string) []byte {
+	t.Helper()
+	r, err := storage.Open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	sealed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+func TestBlobRoundTrip(t *testing.T) {
+	dataKey := testDataKey(t)
+	for _, size := range blobSizes {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			data := testPlaintext(size)
+			storage := putTestBlob(t, data, dataKey)
+
+			// Every segment but the last is full, and the last is sealed
+			// even when it is empty.
+			segments := size/blobSegmentSize + 1
+			wantSealed := blobHeaderSize + size + segments*blobTagSize
+			if got := len(sealedBlob(t, storage, "blob")); got != wantSealed {
+				t.Errorf("sealed blob is %d bytes, want %d", got, wantSealed)
+			}
+
+			r, err := openBlob(storage, "blob", dataKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			// An odd buffer size makes reads straddle segment boundaries.
+			var got []byte
+			buf := make([]byte, 1000)
+			for {
+				n, err := r.Read(buf)
+				got = append(got, buf[:n]...)
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("decrypted %d bytes that differ from the %d encrypted", len(got), len(data))
+			}
+			if end, err := r.Seek(0, io.SeekEnd); err != nil || end != int64(size) {
+				t.Errorf("Seek to end = %d, %v; want %d", end, err, size)
+			}
+		})
+	}
+}
+
+func TestBlobSeek(t *testing.T) {
+	dataKey := testDataKey(t)
+	data := testPlaintext(3*blobSegmentSize + 5)
+	storage := putTestBlob(t, data, dataKey)
+	r, err := openBlob(storage, "blob", dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	offsets := []int64{
+		blobSegmentSize - 1, blobSegmentSize, blobSegmentSize + 1,
+		0, 3 * blobSegmentSize, 3*blobSegmentSize + 4, 2*blobSegmentSize - 3,
+	}
+	for _, offset := range offsets {
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 10)
+		n, err := io.ReadFull(r, buf)
+		want := data[offset:min(offset+10, int64(len(data)))]
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("reading 10 bytes at %d: %v", offset, err)
+			continue
+		}
+		if !bytes.Equal(buf[:n], want) {
+			t.Errorf("read %x at %d, want %x", buf[:n], offset, want)
+		}
+	}
+
+	if _, err := r.Seek(int64(len(data)), io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := r.Read(make([]byte, 1)); n != 0 || !errors.Is(err, io.EOF) {
+		t.Errorf("Read at the end = %d, %v; want io.EOF", n, err)
+	}
+}
+
+func TestBlobTampering(t *testing.T) {
+	dataKey := testDataKey(t)
+	sealedSegment := blobSegmentSize + blobTagSize
+
+	tests := []struct {
+		name   string
+		size   int
+		tamper func(sealed []byte) []byte
+	}{
+		{"FlippedBit", 2*blobSegmentSize + 10, func(sealed []byte) []byte {
+			sealed[blobHeaderSize+sealedSegment+3] ^= 1
+			return sealed
+		}},
+		{"DroppedMiddleSegment", 3*blobSegmentSize + 5, func(sealed []byte) []byte {
+			start := blobHeaderSize + sealedSegment
+			return append(sealed[:start:start], sealed[start+sealedSegment:]...)
+		}},
+		{"SwappedSegments", 2*blobSegmentSize + 10, func(sealed []byte) []byte {
+			first := blobHeaderSize
+			second := first + sealedSegment
+			swapped := append([]byte{}, sealed[:first]...)
+			swapped = append(swapped, sealed[second:second+sealedSegment]...)
+			swapped = append(swapped, sealed[first:second]...)
+			return append(swapped, sealed[second+sealedSegment:]...)
+		}},
+		{"DroppedEmptyFinalSegment", 2 * blobSegmentSize, func(sealed []byte) []byte {
+			return sealed[:len(sealed)-blobTagSize]
+		}},
+		{"CutAtSegmentBoundary", 2*blobSegmentSize + 10, func(sealed []byte) []byte {
+			// What is left ends in a full segment sealed as not final.
+			return sealed[:blobHeaderSize+2*sealedSegment]
+		}},
+		{"CutInsideTag", 10, func(sealed []byte) []byte {
+			return sealed[:blobHeaderSize+blobTagSize-1]
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := putTestBlob(t, testPlaintext(tt.size), dataKey)
+			if _, err := storage.Put("blob", bytes.NewReader(tt.tamper(sealedBlob(t, storage, "blob")))); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := openBlob(storage, "blob", dataKey)
+			if err == nil {
+				_, err = io.ReadAll(r)
+				r.Close()
+			}
+			if !errors.Is(err, ErrBlobCorrupt) {
+				t.Errorf("reading the tampered blob returned %v, want ErrBlobCorrupt", err)
+			}
+		})
+	}
+}
+
+func TestBlobWrongKey(t *testing.T) {
+	storage := putTestBlob(t, testPlaintext(100), testDataKey(t))
+	r, err := openBlob(storage, "blob", testDataKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrBlobCorrupt) {
+		t.Errorf("reading with the wrong key returned %v, want ErrBlobCorrupt", err)
+	}
+}
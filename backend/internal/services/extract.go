@@ -0,0 +1,171 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// maxExtractedText caps the text indexed per document. Postgres refuses
+// tsvectors over 1 MB, and the start of a document is what matters most.
+const maxExtractedText = 512 << 10
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// officeTextParts lists, per Office Open XML type, the archive members that
+// hold the document's text.
+var officeTextParts = map[string]string{
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   "word/document.xml",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         "xl/sharedStrings.xml",
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": "ppt/slides/slide*.xml",
+}
+
+// canExtractText reports whether extractText understands a MIME type.
+func canExtractText(mimeType string) bool {
+	mimeType = baseMimeType(mimeType)
+	_, office := officeTextParts[mimeType]
+	return strings.HasPrefix(mimeType, "text/") || mimeType == "application/pdf" || office
+}
+
+// extractText returns the plain text of a text, PDF or Office document.
+func extractText(r io.ReadSeeker, size int64, mimeType string) (text string, err error) {
+	// The PDF parser panics on some malformed files.
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("could not parse document: %v", p)
+		}
+	}()
+
+	mimeType = baseMimeType(mimeType)
+	switch {
+	case strings.HasPrefix(mimeType, "text/"):
+		data, err := io.ReadAll(io.LimitReader(r, maxExtractedText))
+		if err != nil {
+			return "", err
+		}
+		text = string(data)
+		if mimeType == "text/html" {
+			text = htmlTag.ReplaceAllString(text, " ")
+		}
+	case mimeType == "application/pdf":
+		doc, err := pdf.NewReader(&seekReaderAt{r: r}, size)
+		if err != nil {
+			return "", err
+		}
+		plain, err := doc.GetPlainText()
+		if err != nil {
+			return "", err
+		}
+		data, err := io.ReadAll(io.LimitReader(plain, maxExtractedText))
+		if err != nil {
+			return "", err
+		}
+		text = string(data)
+	default:
+		pattern, ok := officeTextParts[mimeType]
+		if !ok {
+			return "", fmt.Errorf("cannot extract text from %s", mimeType)
+		}
+		if text, err = extractOfficeText(&seekReaderAt{r: r}, size, pattern); err != nil {
+			return "", err
+		}
+	}
+	return cleanExtractedText(text), nil
+}
+
+// extractOfficeText collects the text runs (<w:t>, <a:t>, <t>) of the
+// archive members matching pattern, in member order.
+func extractOfficeText(r io.ReaderAt, size int64, pattern string) (string, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return "", err
+	}
+
+	var members []*zip.File
+	for _, member := range archive.File {
+		if ok, _ := path.Match(pattern, member.Name); ok {
+			members = append(members, member)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return naturalLess(members[i].Name, members[j].Name) })
+
+	var text strings.Builder
+	for _, member := range members {
+		rc, err := member.Open()
+		if err != nil {
+			return "", err
+		}
+		decoder := xml.NewDecoder(io.LimitReader(rc, 32*maxExtractedText))
+		inText := false
+		for text.Len() < maxExtractedText {
+			token, err := decoder.Token()
+			if err != nil {
+				break
+			}
+			switch t := token.(type) {
+			case xml.StartElement:
+				inText = t.Name.Local == "t"
+			case xml.EndElement:
+				switch t.Name.Local {
+				case "t":
+					inText = false
+				case "p", "si", "tab", "br":
+					text.WriteByte('\n')
+				}
+			case xml.CharData:
+				if inText {
+					text.Write(t)
+				}
+			}
+		}
+		rc.Close()
+	}
+	return text.String(), nil
+}
+
+// cleanExtractedText makes extracted text safe to store in Postgres, which
+// rejects NUL bytes and invalid UTF-8.
+func cleanExtractedText(text string) string {
+	if len(text) > maxExtractedText {
+		text = text[:maxExtractedText]
+	}
+	text = strings.ToValidUTF8(text, "")
+	return strings.ReplaceAll(text, "\x00", "")
+}
+
+func baseMimeType(mimeType string) string {
+	base, _, _ := strings.Cut(mimeType, ";")
+	return strings.ToLower(strings.TrimSpace(base))
+}
+
+// naturalLess orders names so that slide2.xml sorts before slide10.xml.
+func naturalLess(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// seekReaderAt adapts a ReadSeeker to the ReaderAt the PDF and zip readers
+// need. It is not safe for concurrent use.
+type seekReaderAt struct {
+	r io.ReadSeeker
+}
+
+func (s *seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := s.r.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(s.r, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
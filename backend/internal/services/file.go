@@ -2,68 +2,75 @@
 package services
 
 import (
+	"database/sql"
 	"errors"
+	"strings"
+	"time"
+	"unicode"
+
 	"filevault-backend/internal/database"
 	"filevault-backend/internal/models"
-	"io"
-	"mime/multipart"
-	"os"
-	"path/filepath"
-	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-type StorageService struct {
-	UploadPath string
-}
+type FileService struct {
+	storageService  StorageService
+	jobService      *JobService
+	scanner         Scanner  // nil when malware scanning is disabled
+	keyRing         *KeyRing // nil when blobs are stored in plaintext
+	maxFileSize     int64
+	defaultQuota    int64
+	quotaAccounting string
+	trashRetention  time.Duration
 
-func NewStorageService(uploadPath string) *StorageService {
-	return &StorageService{
-		UploadPath: uploadPath,
-	}
+	scanFailurePolicy string
 }
 
-func (s *StorageService) SaveFile(filename string, file multipart.File) error {
-	filePath := filepath.Join(s.UploadPath, filename)
-	dst, err := os.Create(filePath)
-	if err != nil {
-		return err
+func NewFileService(storageService StorageService, jobService *JobService, scanner Scanner, scanFailurePolicy string, keyRing *KeyRing, maxFileSize int64, defaultQuota int64, quotaAccounting string, trashRetention time.Duration) *FileService {
+	s := &FileService{
+		storageService:    storageService,
+		jobService:        jobService,
+		scanner:           scanner,
+		keyRing:           keyRing,
+		maxFileSize:       maxFileSize,
+		defaultQuota:      defaultQuota,
+		quotaAccounting:   quotaAccounting,
+		trashRetention:    trashRetention,
+		scanFailurePolicy: scanFailurePolicy,
 	}
-	defer dst.Close()
-	_, err = io.Copy(dst, file)
-	return err
-}
-
-func (s *StorageService) Get(filename string) ([]byte, error) {
-	filePath := filepath.Join(s.UploadPath, filename)
-	return os.ReadFile(filePath)
-}
-
-func (s *StorageService) Delete(filename string) error {
-	filePath := filepath.Join(s.UploadPath, filename)
-	return os.Remove(filePath)
+	s.registerJobs()
+	return s
 }
 
-type FileService struct {
-	storageService *StorageService
-	maxFileSize    int64
-}
-
-func NewFileService(storageService *StorageService, maxFileSize int64) *FileService {
-	return &FileService{
-		storageService: storageService,
-		maxFileSize:    maxFileSize,
+// createFile stores a new file record together with its first version.
+func createFile(tx *gorm.DB, file *models.File) error {
+	file.CurrentVersion = 1
+	if err := tx.Create(file).Error; err != nil {
+		return err
 	}
+	return tx.Create(&models.FileVersion{
+		FileID:        file.ID,
+		Version:       1,
+		FileContentID: file.FileContentID,
+		UploadedBy:    file.UserID,
+	}).Error
 }
 
-func (s *FileService) Create(file *models.File) error {
-	return database.DB.Create(file).Error
+// FilePage is one page of a user's file listing.
+type FilePage struct {
+	Files      []*models.File
+	Total      int64  // Matching files across all pages
+	NextCursor string // Empty on the last page
 }
 
-func (s *FileService) GetByUserID(userID uint, filters *models.SearchFilters) ([]*models.File, error) {
-	var files []*models.File
-	query := database.DB.Preload("Content").Preload("User").Where("user_id = ?", userID)
+// GetByUserID lists a user's files matching filters, one page at a time.
+// Pages are addressed either by number or, for deep listings, by the cursor
+// returned with the previous page; filters.Page and filters.Limit are set to
+// the values actually used.
+func (s *FileService) GetByUserID(userID uint, filters *models.SearchFilters) (*FilePage, error) {
+	query := database.DB.Model(&models.File{}).Where("files.user_id = ?", userID)
 	query = query.Joins("JOIN file_contents ON file_contents.id = files.file_content_id")
 
 	if filters.Filename != "" {
@@ -72,6 +79,22 @@ func (s *FileService) GetByUserID(userID uint, filters *models.SearchFilters) ([
 	if filters.MimeType != "" {
 		query = query.Where("file_contents.mime_type LIKE ?", filters.MimeType+"%")
 	}
+	if filters.FolderID != nil {
+		if *filters.FolderID == 0 {
+			query = query.Where("files.folder_id IS NULL")
+		} else {
+			query = query.Where("files.folder_id = ?", *filters.FolderID)
+		}
+	}
+	if names := searchTagNames(filters.Tags); len(names) > 0 {
+		tagged := database.DB.Table("file_tags").Select("file_tags.file_id").
+			Joins("JOIN tags ON tags.id = file_tags.tag_id").
+			Where("tags.user_id = ? AND tags.name IN ?", userID, names)
+		if filters.TagMatch == "all" {
+			tagged = tagged.Group("file_tags.file_id").Having("COUNT(DISTINCT tags.id) = ?", len(names))
+		}
+		query = query.Where("files.id IN (?)", tagged)
+	}
 	if filters.MinSize > 0 {
 		query = query.Where("file_contents.file_size >= ?", filters.MinSize)
 	}
@@ -88,88 +111,235 @@ func (s *FileService) GetByUserID(userID uint, filters *models.SearchFilters) ([
 			query = query.Where("files.created_at <= ?", t.Add(24*time.Hour-time.Nanosecond))
 		}
 	}
+	if filters.Query != "" {
+		query = query.Where(fullTextMatch, sql.Named("q", filters.Query))
+	}
+	// The filtered query is shared by the count and the page.
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, err
+	}
 
-	err := query.Order("files.created_at DESC").Find(&files).Error
-	return files, err
+	if filters.Page == 0 {
+		filters.Page = 1
+	}
+	if filters.Limit == 0 {
+		filters.Limit = 20
+	}
+	if filters.Sort == "" && filters.Query != "" {
+		filters.Sort = "relevance"
+	}
+	if filters.Sort == "" || (filters.Sort == "relevance" && filters.Query == "") {
+		filters.Sort = "created_at"
+	}
+	if filters.Order == "" {
+		filters.Order = "desc"
+	}
+	column := fileSortColumns[filters.Sort]
+	direction := strings.ToUpper(filters.Order)
+
+	// The page is selected as IDs first so search results can carry their
+	// rank and highlight, then loaded with their associations.
+	page := query.Select("files.id").
+		Order(column + " " + direction).Order("files.id " + direction).
+		Limit(filters.Limit + 1)
+	if filters.Query != "" {
+		page = page.Select("files.id, "+fullTextRank+" AS search_rank, "+fullTextHighlight+" AS highlight", sql.Named("q", filters.Query))
+	}
+	if filters.Cursor != "" {
+		// Ranks are not stable enough to resume from, so relevance is paged by number.
+		if filters.Sort == "relevance" {
+			return nil, ErrInvalidCursor
+		}
+		value, id, err := decodeFileCursor(filters.Cursor, filters.Sort, filters.Order)
+		if err != nil {
+			return nil, err
+		}
+		comparison := "<"
+		if direction == "ASC" {
+			comparison = ">"
+		}
+		page = page.Where("("+column+", files.id) "+comparison+" (?, ?)", value, id)
+	} else {
+		page = page.Offset((filters.Page - 1) * filters.Limit)
+	}
+
+	var hits []searchHit
+	if err := page.Scan(&hits).Error; err != nil {
+		return nil, err
+	}
+	files, err := loadHits(hits)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &FilePage{Files: files, Total: total}
+	if len(files) > filters.Limit {
+		result.Files = files[:filters.Limit]
+		if filters.Sort != "relevance" {
+			result.NextCursor = encodeFileCursor(result.Files[filters.Limit-1], filters.Sort, filters.Order)
+		}
+	}
+	return result, nil
 }
 
 func (s *FileService) GetByID(id uint) (*models.File, error) {
 	var file models.File
-	err := database.DB.Preload("Content").Preload("User").First(&file, id).Error
+	err := database.DB.Preload("Content").Preload("User").Preload("Tags").First(&file, id).Error
 	if err != nil {
 		return nil, err
 	}
 	return &file, nil
 }
 
-// THE FIX: This function is now more robust.
+// DeleteFileAndContent permanently deletes a file, whether or not it is in
+// the trash, and releases its content.
 func (s *FileService) DeleteFileAndContent(fileID uint) error {
 	return database.DB.Transaction(func(tx *gorm.DB) error {
-		// 1. Find the file record to get the content ID
-		var fileToDelete models.File
-		if err := tx.First(&fileToDelete, fileID).Error; err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				return nil // File already deleted, success.
-			}
-			return err
+		return s.deleteFileAndContent(tx, fileID)
+	})
+}
+
+func (s *FileService) deleteFileAndContent(tx *gorm.DB, fileID uint) error {
+	// 1. Find the file record and every content its versions point at
+	var fileToDelete models.File
+	if err := tx.Unscoped().First(&fileToDelete, fileID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil // File already deleted, success.
 		}
-		contentID := fileToDelete.FileContentID
+		return err
+	}
+	var references []contentReferences
+	if err := tx.Model(&models.FileVersion{}).Select("file_content_id, COUNT(*) AS count").
+		Where("file_id = ?", fileID).Group("file_content_id").Scan(&references).Error; err != nil {
+		return err
+	}
+	if len(references) == 0 {
+		references = []contentReferences{{FileContentID: fileToDelete.FileContentID, Count: 1}}
+	}
 
-		// 2. Delete the specific file record
-		if err := tx.Delete(&models.File{}, fileID).Error; err != nil {
+	// 2. Delete the file's history, shares, keys and tags, which refer to
+	// the file record, and then the record itself. Shares are
+	// soft-deleted elsewhere, so they have to be deleted unscoped.
+	if err := tx.Where("file_id = ?", fileID).Delete(&models.FileVersion{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Where("file_id = ?", fileID).Delete(&models.FileShare{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("file_id = ?", fileID).Delete(&models.FileKey{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Exec("DELETE FROM file_tags WHERE file_id = ?", fileID).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Delete(&models.File{}, fileID).Error; err != nil {
+		return err
+	}
+
+	// 3. Release the contents, deleting those nothing references any more
+	for _, ref := range references {
+		if err := s.releaseReferences(tx, ref.FileContentID, ref.Count); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		// 3. Count how many other files still reference the same content
-		var remainingReferences int64
-		tx.Model(&models.File{}).Where("file_content_id = ?", contentID).Count(&remainingReferences)
-
-		// 4. If no files are left referencing this content, delete the content and the physical file
-		if remainingReferences == 0 {
-			var contentToDelete models.FileContent
-			if err := tx.First(&contentToDelete, contentID).Error; err != nil {
-				// Content might already be gone, which is fine.
-				if errors.Is(err, gorm.ErrRecordNotFound) {
-					return nil
-				}
-				return err
-			}
-
-			// Delete physical file from storage
-			if err := s.storageService.Delete(contentToDelete.SHA256Hash); err != nil {
-				// Log the error but don't fail the transaction, as the DB record is more critical.
-				// In a real app, a cleanup job would handle orphaned files.
-			}
-
-			// Delete the content record from the database
-			return tx.Delete(&contentToDelete).Error
-		} else {
-			// Optional: Keep the reference_count column in sync for statistics
-			tx.Model(&models.FileContent{}).Where("id = ?", contentID).Update("reference_count", remainingReferences)
+func (s *FileService) Rename(file *models.File, filename string) error {
+	filename, err := validFileName(filename)
+	if err != nil {
+		return err
+	}
+	if err := database.DB.Model(file).Update("original_filename", filename).Error; err != nil {
+		return err
+	}
+	file.OriginalFilename = filename
+	return nil
+}
+
+// validFileName checks a file name given by a client, as uploaded or renamed.
+// Names are path elements to folder paths and appear in download headers.
+func validFileName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." || len(name) > 255 || strings.ContainsAny(name, `/\`) {
+		return "", ErrInvalidFileName
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return "", ErrInvalidFileName
 		}
+	}
+	return name, nil
+}
 
-		return nil
-	})
+// contentReferences is how many versions of a file use one content row.
+type contentReferences struct {
+	FileContentID uint
+	Count         int64
 }
 
+// releaseReferences drops n references to a content row. A content row
+// counts a reference for every file version using it, and one for every
+// upload StoreContent has handed it to that has not been saved as a version
+// yet, so content in flight is never deleted. Content nothing references any
+// more is deleted, and its blob by a job that only runs if tx commits.
+func (s *FileService) releaseReferences(tx *gorm.DB, contentID uint, n int64) error {
+	var content models.FileContent
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&content, contentID).Error; err != nil {
+		// Content might already be gone, which is fine.
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if int64(content.ReferenceCount) > n {
+		return tx.Model(&content).UpdateColumn("reference_count", gorm.Expr("reference_count - ?", n)).Error
+	}
+	if err := tx.Delete(&content).Error; err != nil {
+		return err
+	}
+	_, err := s.jobService.EnqueueTx(tx, JobDeleteBlob, blobJob{Key: content.SHA256Hash})
+	return err
+}
 
+// deleteBlob deletes a content blob, or its quarantined copy, and any
+// renditions made from it.
+func (s *FileService) deleteBlob(hash string) error {
+	for _, key := range append([]string{hash, QuarantinePrefix + hash}, thumbnailKeys(hash)...) {
+		if err := s.storageService.Delete(key); err != nil && !errors.Is(err, ErrObjectNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// IncrementDownloadCount counts a download without touching updated_at,
+// which downloads serve as Last-Modified and resumed downloads check through
+// If-Range.
 func (s *FileService) IncrementDownloadCount(id uint) error {
 	return database.DB.Model(&models.File{}).Where("id = ?", id).
-		Update("download_count", gorm.Expr("download_count + 1")).Error
+		UpdateColumn("download_count", gorm.Expr("download_count + 1")).Error
 }
 
 func (s *FileService) GetStorageStats(userID uint) (*models.StorageStats, error) {
-	var userFiles []models.File
-	if err := database.DB.Preload("Content").Where("user_id = ?", userID).Find(&userFiles).Error; err != nil {
+	// Every version counts, since old versions keep their content stored.
+	var versions []models.FileVersion
+	if err := database.DB.Preload("Content").
+		Joins("JOIN files ON files.id = file_versions.file_id").
+		Where("files.user_id = ?", userID).Find(&versions).Error; err != nil {
 		return nil, err
 	}
 
 	var originalSize int64 = 0
 	uniqueContents := make(map[uint]models.FileContent)
-	for _, file := range userFiles {
-		originalSize += file.Content.FileSize
-		if _, ok := uniqueContents[file.FileContentID]; !ok {
-			uniqueContents[file.FileContentID] = file.Content
+	for _, version := range versions {
+		originalSize += version.Content.FileSize
+		if _, ok := uniqueContents[version.FileContentID]; !ok {
+			uniqueContents[version.FileContentID] = version.Content
 		}
 	}
 
@@ -178,8 +348,14 @@ func (s *FileService) GetStorageStats(userID uint) (*models.StorageStats, error)
 		totalUsed += content.FileSize
 	}
 
-	var user models.User
-	database.DB.First(&user, userID)
+	quota, err := s.UserQuota(userID)
+	if err != nil {
+		return nil, err
+	}
+	quotaUsed, err := s.QuotaUsage(userID)
+	if err != nil {
+		return nil, err
+	}
 
 	savingsBytes := originalSize - totalUsed
 	savingsPercentage := 0.0
@@ -192,6 +368,8 @@ func (s *FileService) GetStorageStats(userID uint) (*models.StorageStats, error)
 		OriginalSize:      originalSize,
 		SavingsBytes:      savingsBytes,
 		SavingsPercentage: savingsPercentage,
-		Quota:             user.StorageQuota,
+		Quota:             quota,
+		QuotaUsed:         quotaUsed,
+		QuotaAccounting:   s.quotaAccounting,
 	}, nil
-}
\ No newline at end of file
+}
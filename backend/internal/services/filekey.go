@@ -0,0 +1,73 @@
+package services
+
+import (
+	"errors"
+	"log"
+
+	"filevault-backend/internal/database"
+	"filevault-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Files the client encrypts before upload are stored as opaque blobs with the
+// MIME type utils.EncryptedMimeType, which is never sniffed, scanned, indexed
+// or thumbnailed. Deduplication still applies, but to the ciphertext: clients
+// that derive file keys from the plaintext (convergent encryption) share
+// blobs for identical files, while everyone else gets a blob per upload.
+var (
+	ErrEncryptionMismatch = errors.New("encrypted and unencrypted content cannot be mixed in one file")
+	ErrWrappedKeyRequired = errors.New("a wrapped file key is required for encrypted files")
+	ErrNoPublicKey        = errors.New("user has not published a public key")
+	ErrFileKeyNotFound    = errors.New("no file key stored for this user")
+	ErrNotEncrypted       = errors.New("file is not encrypted")
+)
+
+// createEncrypted creates a client-encrypted file together with the
+// uploader's copy of its key.
+func createEncrypted(tx *gorm.DB, file *models.File, wrappedKey string) error {
+	if err := createFile(tx, file); err != nil {
+		return err
+	}
+	return tx.Create(&models.FileKey{FileID: file.ID, UserID: file.UserID, WrappedKey: wrappedKey}).Error
+}
+
+// releaseContent drops the reference StoreContent took on content that ended
+// up not being saved to any file.
+func (s *FileService) releaseContent(content *models.FileContent) {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		return s.releaseReferences(tx, content.ID, 1)
+	})
+	if err != nil {
+		log.Printf("Releasing unsaved content %s failed: %v", content.SHA256Hash, err)
+	}
+}
+
+// GetFileKey returns a user's copy of the key of an encrypted file.
+func (s *FileService) GetFileKey(file *models.File, userID uint) (*models.FileKey, error) {
+	if !file.Encrypted {
+		return nil, ErrNotEncrypted
+	}
+	var key models.FileKey
+	err := database.DB.Where("file_id = ? AND user_id = ?", file.ID, userID).First(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrFileKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// SetFileKey replaces a user's copy of the key of an encrypted file, for
+// example after the client rewrapped it with a new public key.
+func (s *FileService) SetFileKey(file *models.File, userID uint, wrappedKey string) (*models.FileKey, error) {
+	key, err := s.GetFileKey(file, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := database.DB.Model(key).Update("wrapped_key", wrappedKey).Error; err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,307 @@
+package services
+
+import (
+	"errors"
+	"strings"
+
+	"filevault-backend/internal/database"
+	"filevault-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrFolderNotFound    = errors.New("folder not found")
+	ErrFolderExists      = errors.New("a folder with this name already exists here")
+	ErrFolderCycle       = errors.New("a folder cannot be moved into itself or one of its subfolders")
+	ErrFolderNotEmpty    = errors.New("folder is not empty")
+	ErrInvalidFolderName = errors.New("folder names cannot be empty, '.', '..' or contain slashes")
+)
+
+// FolderService manages the per-user folder tree. Folder IDs passed in are
+// always scoped to a user, so one user can never address another's folders.
+type FolderService struct {
+	fileService *FileService
+}
+
+func NewFolderService(fileService *FileService) *FolderService {
+	return &FolderService{
+		fileService: fileService,
+	}
+}
+
+func (s *FolderService) Create(userID uint, parentID *uint, name string) (*models.Folder, error) {
+	name, err := validFolderName(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkParent(userID, parentID); err != nil {
+		return nil, err
+	}
+	if err := s.checkNameFree(userID, parentID, name, 0); err != nil {
+		return nil, err
+	}
+
+	folder := &models.Folder{
+		UserID:   userID,
+		ParentID: parentID,
+		Name:     name,
+	}
+	if err := database.DB.Create(folder).Error; err != nil {
+		return nil, err
+	}
+	return folder, s.fillPath(folder)
+}
+
+// Get returns one of the user's folders, with its path filled in.
+func (s *FolderService) Get(userID, id uint) (*models.Folder, error) {
+	folder, err := s.find(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	return folder, s.fillPath(folder)
+}
+
+// List returns the folders directly inside parentID, or the user's top-level
+// folders when parentID is nil.
+func (s *FolderService) List(userID uint, parentID *uint) ([]models.Folder, error) {
+	var folders []models.Folder
+	err := scopeParent(database.DB.Where("user_id = ?", userID), parentID).
+		Order("name ASC").Find(&folders).Error
+	return folders, err
+}
+
+func (s *FolderService) Rename(folder *models.Folder, name string) error {
+	name, err := validFolderName(name)
+	if err != nil {
+		return err
+	}
+	if err := s.checkNameFree(folder.UserID, folder.ParentID, name, folder.ID); err != nil {
+		return err
+	}
+	if err := database.DB.Model(folder).Update("name", name).Error; err != nil {
+		return err
+	}
+	folder.Name = name
+	return s.fillPath(folder)
+}
+
+// Move re-parents a folder; a nil parentID moves it to the root.
+func (s *FolderService) Move(folder *models.Folder, parentID *uint) error {
+	if err := s.checkParent(folder.UserID, parentID); err != nil {
+		return err
+	}
+	// Walk up from the new parent; reaching the folder itself means the move
+	// would detach it from the tree.
+	for id := parentID; id != nil; {
+		if *id == folder.ID {
+			return ErrFolderCycle
+		}
+		parent, err := s.find(folder.UserID, *id)
+		if err != nil {
+			return err
+		}
+		id = parent.ParentID
+	}
+	if err := s.checkNameFree(folder.UserID, parentID, folder.Name, folder.ID); err != nil {
+		return err
+	}
+
+	if err := database.DB.Model(folder).Update("parent_id", parentID).Error; err != nil {
+		return err
+	}
+	folder.ParentID = parentID
+	return s.fillPath(folder)
+}
+
+// Delete removes a folder. A folder that still holds files or subfolders is
+// only removed when recursive is set, in which case the subfolders are deleted
+// too and the files are moved to the trash. It returns the number of files
+// trashed.
+func (s *FolderService) Delete(folder *models.Folder, recursive bool) (int, error) {
+	folderIDs := []uint{folder.ID}
+	for next := []uint{folder.ID}; len(next) > 0; {
+		var children []uint
+		if err := database.DB.Model(&models.Folder{}).Where("parent_id IN ?", next).Pluck("id", &children).Error; err != nil {
+			return 0, err
+		}
+		folderIDs = append(folderIDs, children...)
+		next = children
+	}
+
+	var fileIDs []uint
+	if err := database.DB.Model(&models.File{}).Where("folder_id IN ?", folderIDs).Pluck("id", &fileIDs).Error; err != nil {
+		return 0, err
+	}
+	if !recursive && (len(folderIDs) > 1 || len(fileIDs) > 0) {
+		return 0, ErrFolderNotEmpty
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if len(fileIDs) > 0 {
+			if err := tx.Where("id IN ?", fileIDs).Delete(&models.File{}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Where("id IN ?", folderIDs).Delete(&models.Folder{}).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(fileIDs), nil
+}
+
+// Resolve looks up a slash-separated path such as "/Projects/2024". The last
+// element may name either a folder or a file, so exactly one of the returned
+// pointers is set on success.
+func (s *FolderService) Resolve(userID uint, path string) (*models.Folder, *models.File, error) {
+	names := splitPath(path)
+	if len(names) == 0 {
+		return nil, nil, ErrFolderNotFound
+	}
+
+	var parent *models.Folder
+	for i, name := range names {
+		var parentID *uint
+		if parent != nil {
+			parentID = &parent.ID
+		}
+
+		var folder models.Folder
+		err := scopeParent(database.DB.Where("user_id = ? AND name = ?", userID, name), parentID).First(&folder).Error
+		if err == nil {
+			parent = &folder
+			continue
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, err
+		}
+
+		if i == len(names)-1 {
+			var file models.File
+			err := scopeFolder(database.DB.Preload("Content").Preload("User"), parentID).
+				Where("user_id = ? AND original_filename = ?", userID, name).
+				Order("created_at DESC").First(&file).Error
+			if err == nil {
+				return nil, &file, nil
+			}
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, nil, err
+			}
+		}
+		return nil, nil, ErrFolderNotFound
+	}
+	return parent, nil, s.fillPath(parent)
+}
+
+// ResolveFolder is Resolve restricted to folders; "/" resolves to the root,
+// reported as a nil ID.
+func (s *FolderService) ResolveFolder(userID uint, path string) (*uint, error) {
+	if len(splitPath(path)) == 0 {
+		return nil, nil
+	}
+	folder, _, err := s.Resolve(userID, path)
+	if err != nil {
+		return nil, err
+	}
+	if folder == nil {
+		return nil, ErrFolderNotFound
+	}
+	return &folder.ID, nil
+}
+
+// MoveFile puts a file into one of its owner's folders; a nil folderID moves
+// it to the root.
+func (s *FolderService) MoveFile(file *models.File, folderID *uint) error {
+	if err := s.checkParent(file.UserID, folderID); err != nil {
+		return err
+	}
+	if err := database.DB.Model(file).Update("folder_id", folderID).Error; err != nil {
+		return err
+	}
+	file.FolderID = folderID
+	return nil
+}
+
+// CheckFolder reports ErrFolderNotFound unless folderID is nil (the root) or
+// one of the user's folders.
+func (s *FolderService) CheckFolder(userID uint, folderID *uint) error {
+	return s.checkParent(userID, folderID)
+}
+
+func (s *FolderService) find(userID, id uint) (*models.Folder, error) {
+	var folder models.Folder
+	err := database.DB.Where("id = ? AND user_id = ?", id, userID).First(&folder).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrFolderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
+
+func (s *FolderService) checkParent(userID uint, parentID *uint) error {
+	if parentID == nil {
+		return nil
+	}
+	_, err := s.find(userID, *parentID)
+	return err
+}
+
+func (s *FolderService) checkNameFree(userID uint, parentID *uint, name string, exceptID uint) error {
+	var count int64
+	scopeParent(database.DB.Model(&models.Folder{}), parentID).
+		Where("user_id = ? AND name = ? AND id <> ?", userID, name, exceptID).
+		Count(&count)
+	if count > 0 {
+		return ErrFolderExists
+	}
+	return nil
+}
+
+func (s *FolderService) fillPath(folder *models.Folder) error {
+	names := []string{folder.Name}
+	for id := folder.ParentID; id != nil; {
+		parent, err := s.find(folder.UserID, *id)
+		if err != nil {
+			return err
+		}
+		names = append([]string{parent.Name}, names...)
+		id = parent.ParentID
+	}
+	folder.Path = "/" + strings.Join(names, "/")
+	return nil
+}
+
+func validFolderName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", ErrInvalidFolderName
+	}
+	return name, nil
+}
+
+func splitPath(path string) []string {
+	var names []string
+	for _, name := range strings.Split(path, "/") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func scopeParent(db *gorm.DB, parentID *uint) *gorm.DB {
+	if parentID == nil {
+		return db.Where("parent_id IS NULL")
+	}
+	return db.Where("parent_id = ?", *parentID)
+}
+
+func scopeFolder(db *gorm.DB, folderID *uint) *gorm.DB {
+	if folderID == nil {
+		return db.Where("folder_id IS NULL")
+	}
+	return db.Where("folder_id = ?", *folderID)
+}
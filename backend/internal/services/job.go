@@ -0,0 +1,369 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"filevault-backend/internal/database"
+	"filevault-backend/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrJobNotFound    = errors.New("job not found")
+	ErrJobNotDead     = errors.New("only dead jobs can be retried")
+	ErrUnknownJobType = errors.New("unknown job type")
+)
+
+const (
+	jobPollInterval = 5 * time.Second
+	jobTimeout      = 10 * time.Minute // Attempts are cancelled after this long
+	jobBaseBackoff  = 30 * time.Second
+	jobMaxBackoff   = time.Hour
+	jobRetention    = 7 * 24 * time.Hour // Succeeded jobs are kept this long for inspection
+)
+
+// JobFunc runs one attempt of a job. Returning an error schedules a retry
+// unless the error was wrapped with permanent.
+type JobFunc func(ctx context.Context, payload json.RawMessage) error
+
+// DeadFunc is told about a job that failed for good, to record whatever the
+// job can no longer do. Retrying the job may still make it succeed later.
+type DeadFunc func(payload json.RawMessage, lastErr string)
+
+// permanentError marks a job failure that retrying cannot fix, such as a
+// corrupt document, so the job goes straight to dead.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+func permanent(err error) error {
+	return permanentError{err}
+}
+
+// JobService is a durable background job queue backed by the jobs table.
+// Workers claim jobs with SELECT ... FOR UPDATE SKIP LOCKED, so any number
+// of workers, in any number of server processes, can share the queue.
+type JobService struct {
+	workers     int
+	maxAttempts int
+	handlers    map[string]JobFunc
+	deadHooks   map[string]DeadFunc
+	wake        chan struct{}
+}
+
+func NewJobService(workers, maxAttempts int) *JobService {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &JobService{
+		workers:     workers,
+		maxAttempts: maxAttempts,
+		handlers:    make(map[string]JobFunc),
+		deadHooks:   make(map[string]DeadFunc),
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Register sets the function that runs jobs of the given type. Handlers must
+// be registered before Start.
+func (s *JobService) Register(jobType string, fn JobFunc) {
+	s.handlers[jobType] = fn
+}
+
+// OnDead sets the function called when a job of the given type is marked
+// dead, whether it failed permanently or ran out of attempts.
+func (s *JobService) OnDead(jobType string, fn DeadFunc) {
+	s.deadHooks[jobType] = fn
+}
+
+// Enqueue adds a job that runs as soon as a worker is free.
+func (s *JobService) Enqueue(jobType string, payload interface{}) (*models.Job, error) {
+	return s.EnqueueTx(database.DB, jobType, payload)
+}
+
+// EnqueueTx adds a job within tx, so it only exists if tx commits.
+func (s *JobService) EnqueueTx(tx *gorm.DB, jobType string, payload interface{}) (*models.Job, error) {
+	if _, ok := s.handlers[jobType]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownJobType, jobType)
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &models.Job{
+		Type:        jobType,
+		Payload:     raw,
+		Status:      models.JobPending,
+		MaxAttempts: s.maxAttempts,
+		RunAt:       time.Now(),
+	}
+	if err := tx.Create(job).Error; err != nil {
+		return nil, err
+	}
+
+	s.notify()
+	return job, nil
+}
+
+// notify wakes an idle worker. A job that is not visible to it yet, because
+// its transaction has not committed, is picked up on the next poll instead.
+func (s *JobService) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start launches the workers and the maintenance loop in the background.
+func (s *JobService) Start() {
+	if s.workers <= 0 {
+		log.Println("Job workers disabled; queued jobs will not run in this process")
+		return
+	}
+	for i := 0; i < s.workers; i++ {
+		go s.work()
+	}
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for ; ; <-ticker.C {
+			if err := s.maintain(); err != nil {
+				log.Printf("Job maintenance failed: %v", err)
+			}
+		}
+	}()
+}
+
+func (s *JobService) work() {
+	for {
+		job, err := s.claim()
+		if err != nil {
+			log.Printf("Claiming job failed: %v", err)
+		} else if job != nil {
+			s.run(job)
+			continue
+		}
+
+		select {
+		case <-s.wake:
+		case <-time.After(jobPollInterval):
+		}
+	}
+}
+
+// claim marks the next due job of a type this process handles as running
+// and returns it, or nil when there is nothing to do.
+func (s *JobService) claim() (*models.Job, error) {
+	types := make([]string, 0, len(s.handlers))
+	for jobType := range s.handlers {
+		types = append(types, jobType)
+	}
+
+	var jobs []models.Job
+	err := database.DB.Raw(`UPDATE jobs SET status = ?, attempts = attempts + 1, locked_at = NOW(), updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM jobs WHERE status = ? AND run_at <= NOW() AND type IN ?
+			ORDER BY run_at, id LIMIT 1 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`, models.JobRunning, models.JobPending, types).Scan(&jobs).Error
+	if err != nil || len(jobs) == 0 {
+		return nil, err
+	}
+	return &jobs[0], nil
+}
+
+func (s *JobService) run(job *models.Job) {
+	ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+	defer cancel()
+
+	err := s.call(ctx, job)
+	now := time.Now()
+	updates := map[string]interface{}{"locked_at": nil}
+	var permanentErr permanentError
+	switch {
+	case err == nil:
+		updates["status"] = models.JobSucceeded
+		updates["completed_at"] = now
+		updates["last_error"] = ""
+	case job.Attempts >= job.MaxAttempts || errors.As(err, &permanentErr):
+		log.Printf("Job %d (%s) failed permanently after %d attempt(s): %v", job.ID, job.Type, job.Attempts, err)
+		updates["status"] = models.JobDead
+		updates["completed_at"] = now
+		updates["last_error"] = err.Error()
+	default:
+		retryAt := now.Add(jobBackoff(job.Attempts))
+		log.Printf("Job %d (%s) failed, retrying at %s: %v", job.ID, job.Type, retryAt.Format(time.RFC3339), err)
+		updates["status"] = models.JobPending
+		updates["run_at"] = retryAt
+		updates["last_error"] = err.Error()
+	}
+
+	// The status check keeps a slow attempt from overwriting a job that
+	// maintain already gave up on.
+	result := database.DB.Model(&models.Job{}).Where("id = ? AND status = ?", job.ID, models.JobRunning).
+		Updates(updates)
+	if result.Error != nil {
+		log.Printf("Recording result of job %d failed: %v", job.ID, result.Error)
+		return
+	}
+	if updates["status"] == models.JobDead && result.RowsAffected > 0 {
+		s.dead(job, err.Error())
+	}
+}
+
+// dead runs the hook for a job that was just marked dead.
+func (s *JobService) dead(job *models.Job, lastErr string) {
+	if hook, ok := s.deadHooks[job.Type]; ok {
+		hook(job.Payload, lastErr)
+	}
+}
+
+func (s *JobService) call(ctx context.Context, job *models.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return s.handlers[job.Type](ctx, job.Payload)
+}
+
+// jobBackoff is the delay before retrying a job that has failed attempts
+// times: 30s, 1m, 2m, ... up to an hour.
+func jobBackoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	delay := jobBaseBackoff
+	for i := 1; i < attempts && delay < jobMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > jobMaxBackoff {
+		delay = jobMaxBackoff
+	}
+	return delay
+}
+
+// maintain requeues jobs whose worker died mid-attempt and deletes old
+// succeeded jobs. Dead jobs are kept until retried.
+func (s *JobService) maintain() error {
+	const lastError = "attempt did not finish; the worker may have stopped"
+	stale := time.Now().Add(-jobTimeout - time.Minute)
+	var requeued []models.Job
+	err := database.DB.Model(&requeued).Clauses(clause.Returning{}).
+		Where("status = ? AND locked_at < ?", models.JobRunning, stale).
+		Updates(map[string]interface{}{
+			"status":       gorm.Expr("CASE WHEN attempts >= max_attempts THEN ? ELSE ? END", models.JobDead, models.JobPending),
+			"completed_at": gorm.Expr("CASE WHEN attempts >= max_attempts THEN NOW() END"),
+			"locked_at":    nil,
+			"run_at":       time.Now(),
+			"last_error":   lastError,
+		}).Error
+	if err != nil {
+		return err
+	}
+	for i := range requeued {
+		if requeued[i].Status == models.JobDead {
+			s.dead(&requeued[i], lastError)
+		}
+	}
+
+	return database.DB.Where("status = ? AND completed_at < ?", models.JobSucceeded, time.Now().Add(-jobRetention)).
+		Delete(&models.Job{}).Error
+}
+
+// List returns jobs, newest first, optionally filtered by status and type.
+func (s *JobService) List(filters *models.JobFilters) ([]models.Job, int64, error) {
+	query := database.DB.Model(&models.Job{})
+	if filters.Status != "" {
+		query = query.Where("status = ?", filters.Status)
+	}
+	if filters.Type != "" {
+		query = query.Where("type = ?", filters.Type)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	if filters.Page == 0 {
+		filters.Page = 1
+	}
+	if filters.Limit == 0 {
+		filters.Limit = 20
+	}
+	var jobs []models.Job
+	err := query.Order("id DESC").Offset((filters.Page - 1) * filters.Limit).Limit(filters.Limit).Find(&jobs).Error
+	return jobs, total, err
+}
+
+func (s *JobService) Get(id uint) (*models.Job, error) {
+	var job models.Job
+	if err := database.DB.First(&job, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrJobNotFound
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (s *JobService) Stats() (*models.JobStats, error) {
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	if err := database.DB.Model(&models.Job{}).Select("status, COUNT(*) AS count").Group("status").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	stats := &models.JobStats{}
+	for _, row := range rows {
+		switch row.Status {
+		case models.JobPending:
+			stats.Pending = row.Count
+		case models.JobRunning:
+			stats.Running = row.Count
+		case models.JobSucceeded:
+			stats.Succeeded = row.Count
+		case models.JobDead:
+			stats.Dead = row.Count
+		}
+	}
+	return stats, nil
+}
+
+// Retry gives a dead job a fresh set of attempts.
+func (s *JobService) Retry(id uint) (*models.Job, error) {
+	result := database.DB.Model(&models.Job{}).Where("id = ? AND status = ?", id, models.JobDead).
+		Updates(map[string]interface{}{
+			"status":       models.JobPending,
+			"attempts":     0,
+			"run_at":       time.Now(),
+			"completed_at": nil,
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	job, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrJobNotDead
+	}
+
+	s.notify()
+	return job, nil
+}
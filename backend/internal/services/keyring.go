@@ -0,0 +1,208 @@
+package services
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"filevault-backend/internal/config"
+	"filevault-backend/internal/database"
+	"filevault-backend/internal/models"
+)
+
+var ErrUnknownMasterKey = errors.New("data key is wrapped by a master key that is not configured")
+
+// KeyRing holds the master keys used for envelope encryption. Every blob is
+// encrypted with its own random data key, and only the data key, wrapped by
+// the active master key, is stored in the database. Older master keys stay in
+// the ring so existing data keys can still be unwrapped until RewrapDataKeys
+// has moved them to the active one.
+//
+// A nil KeyRing means encryption is disabled: new blobs are stored in
+// plaintext and only plaintext blobs can be read.
+type KeyRing struct {
+	keys   map[string][]byte
+	active string
+}
+
+// NewKeyRing loads the master keys from cfg.EncryptionKeys and the file at
+// cfg.EncryptionKeyFile, both lists of "id:base64-key" entries, separated by
+// commas or newlines. The active key is cfg.EncryptionActiveKey, or the last
+// key listed. It returns nil when no keys are configured.
+func NewKeyRing(cfg *config.Config) (*KeyRing, error) {
+	entries := strings.Split(cfg.EncryptionKeys, ",")
+	if cfg.EncryptionKeyFile != "" {
+		f, err := os.Open(cfg.EncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading encryption key file: %w", err)
+		}
+		defer f.Close()
+		lines := bufio.NewScanner(f)
+		for lines.Scan() {
+			if line := strings.TrimSpace(lines.Text()); !strings.HasPrefix(line, "#") {
+				entries = append(entries, line)
+			}
+		}
+		if err := lines.Err(); err != nil {
+			return nil, fmt.Errorf("reading encryption key file: %w", err)
+		}
+	}
+
+	ring := &KeyRing{keys: make(map[string][]byte)}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return nil, errors.New(`encryption keys must be given as "id:base64-key"`)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("encryption key %q must be 32 bytes, base64 encoded", id)
+		}
+		ring.keys[id] = key
+		ring.active = id
+	}
+	if len(ring.keys) == 0 {
+		return nil, nil
+	}
+	if cfg.EncryptionActiveKey != "" {
+		if _, ok := ring.keys[cfg.EncryptionActiveKey]; !ok {
+			return nil, fmt.Errorf("active encryption key %q is not configured", cfg.EncryptionActiveKey)
+		}
+		ring.active = cfg.EncryptionActiveKey
+	}
+	return ring, nil
+}
+
+// ActiveKeyID is the ID of the master key new data keys are wrapped with.
+func (k *KeyRing) ActiveKeyID() string {
+	if k == nil {
+		return ""
+	}
+	return k.active
+}
+
+// NewDataKey generates a data key for a new blob and wraps it with the
+// active master key. It returns a nil key when encryption is disabled.
+func (k *KeyRing) NewDataKey() (dataKey []byte, keyID string, wrapped []byte, err error) {
+	if k == nil {
+		return nil, "", nil, nil
+	}
+	dataKey = make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, "", nil, err
+	}
+	wrapped, err = k.wrap(k.active, dataKey)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return dataKey, k.active, wrapped, nil
+}
+
+// DataKey unwraps a stored data key. An empty wrapped key belongs to a blob
+// stored in plaintext and gives a nil key.
+func (k *KeyRing) DataKey(keyID string, wrapped []byte) ([]byte, error) {
+	if len(wrapped) == 0 {
+		return nil, nil
+	}
+	if k == nil {
+		return nil, ErrUnknownMasterKey
+	}
+	master, ok := k.keys[keyID]
+	if !ok {
+		return nil, ErrUnknownMasterKey
+	}
+	aead, err := newKeyCipher(master)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, ErrBlobCorrupt
+	}
+	nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, []byte(keyID))
+	if err != nil {
+		return nil, ErrBlobCorrupt
+	}
+	return dataKey, nil
+}
+
+func (k *KeyRing) wrap(keyID string, dataKey []byte) ([]byte, error) {
+	aead, err := newKeyCipher(k.keys[keyID])
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	// The key ID is authenticated so a wrapped key cannot be relabelled.
+	return aead.Seal(nonce, nonce, dataKey, []byte(keyID)), nil
+}
+
+func newKeyCipher(master []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(master)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// RewrapDataKeys rewraps every stored data key that is not wrapped by the
+// active master key, so retired master keys can be removed from the ring.
+// Only the wrapped keys change; blobs are not read or rewritten. It returns
+// the number of keys rewrapped.
+func (k *KeyRing) RewrapDataKeys() (int, error) {
+	if k == nil {
+		return 0, errors.New("no encryption keys are configured")
+	}
+
+	rewrapped := 0
+	for _, model := range []interface{}{&models.FileContent{}, &models.UploadSession{}} {
+		for {
+			// Rewrapped rows stop matching, so each pass picks up the next batch.
+			var rows []struct {
+				ID         interface{}
+				KeyID      string
+				WrappedKey []byte
+			}
+			err := database.DB.Model(model).Select("id, key_id, wrapped_key").
+				Where("wrapped_key IS NOT NULL AND key_id <> ?", k.active).
+				Limit(100).Find(&rows).Error
+			if err != nil {
+				return rewrapped, err
+			}
+			if len(rows) == 0 {
+				break
+			}
+
+			for _, row := range rows {
+				dataKey, err := k.DataKey(row.KeyID, row.WrappedKey)
+				if err != nil {
+					return rewrapped, fmt.Errorf("unwrapping data key of %T %v: %w", model, row.ID, err)
+				}
+				wrapped, err := k.wrap(k.active, dataKey)
+				if err != nil {
+					return rewrapped, err
+				}
+				// Matching the old key ID keeps a concurrent rewrap from being overwritten.
+				result := database.DB.Model(model).Where("id = ? AND key_id = ?", row.ID, row.KeyID).
+					Updates(map[string]interface{}{"key_id": k.active, "wrapped_key": wrapped})
+				if result.Error != nil {
+					return rewrapped, result.Error
+				}
+				rewrapped += int(result.RowsAffected)
+			}
+		}
+	}
+	return rewrapped, nil
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"filevault-backend/internal/database"
+	"filevault-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Quota accounting modes. Deduplicated accounting charges a user once per
+// distinct content they reference; logical accounting charges every file at
+// its full size, regardless of deduplication.
+const (
+	QuotaAccountingDeduplicated = "deduplicated"
+	QuotaAccountingLogical      = "logical"
+)
+
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// ValidateQuotaAccounting rejects unknown accounting modes, which would
+// otherwise silently change how users are charged.
+func ValidateQuotaAccounting(mode string) error {
+	switch mode {
+	case QuotaAccountingDeduplicated, QuotaAccountingLogical:
+		return nil
+	}
+	return fmt.Errorf("unknown quota accounting mode %q; use %q or %q", mode, QuotaAccountingDeduplicated, QuotaAccountingLogical)
+}
+
+// quotaLockClass keys the advisory locks taken on users' quotas, apart from
+// the locks taken on content hashes.
+const quotaLockClass = 1
+
+// UserQuota returns the quota that applies to a user, falling back to the
+// configured default when the user has none of their own. A negative quota
+// means unlimited.
+func (s *FileService) UserQuota(userID uint) (int64, error) {
+	return s.userQuota(database.DB, userID)
+}
+
+func (s *FileService) userQuota(db *gorm.DB, userID uint) (int64, error) {
+	var user models.User
+	if err := db.Select("storage_quota").First(&user, userID).Error; err != nil {
+		return 0, err
+	}
+	if user.StorageQuota != nil {
+		return *user.StorageQuota, nil
+	}
+	return s.defaultQuota, nil
+}
+
+// QuotaUsage returns how many bytes count against a user's quota under the
+// configured accounting mode. Old versions of files count too, and so does
+// the declared length of every unfinished resumable upload.
+func (s *FileService) QuotaUsage(userID uint) (int64, error) {
+	return s.quotaUsage(database.DB, userID)
+}
+
+func (s *FileService) quotaUsage(db *gorm.DB, userID uint) (int64, error) {
+	var used int64
+	var err error
+	if s.quotaAccounting == QuotaAccountingLogical {
+		err = userVersions(db, userID).
+			Joins("JOIN file_contents ON file_contents.id = file_versions.file_content_id").
+			Select("COALESCE(SUM(file_contents.file_size), 0)").Scan(&used).Error
+	} else {
+		err = db.Model(&models.FileContent{}).
+			Where("id IN (?)", userVersions(db, userID).Select("file_versions.file_content_id")).
+			Select("COALESCE(SUM(file_size), 0)").Scan(&used).Error
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var reserved int64
+	err = db.Model(&models.UploadSession{}).
+		Where("user_id = ? AND file_id IS NULL", userID).
+		Select("COALESCE(SUM(length), 0)").Scan(&reserved).Error
+	return used + reserved, err
+}
+
+// RemainingQuota returns how many more bytes a user may store; a negative
+// quota disables the check and yields -1.
+func (s *FileService) RemainingQuota(userID uint) (int64, error) {
+	return s.remainingQuota(database.DB, userID, 0)
+}
+
+// remainingQuota is RemainingQuota for an upload that has reserved reserved
+// bytes of the quota, which are available to it.
+func (s *FileService) remainingQuota(db *gorm.DB, userID uint, reserved int64) (int64, error) {
+	quota, err := s.userQuota(db, userID)
+	if err != nil {
+		return 0, err
+	}
+	if quota < 0 {
+		return -1, nil
+	}
+	used, err := s.quotaUsage(db, userID)
+	if err != nil {
+		return 0, err
+	}
+	used -= reserved
+	if used >= quota {
+		return 0, nil
+	}
+	return quota - used, nil
+}
+
+// ChargesUpfront reports whether the full size of an upload is known to count
+// against the quota before its content has been hashed.
+func (s *FileService) ChargesUpfront() bool {
+	return s.quotaAccounting == QuotaAccountingLogical
+}
+
+// checkQuota decides whether a user can take a reference to content with the
+// given hash and size, counting reserved bytes the upload reserved as free.
+func (s *FileService) checkQuota(db *gorm.DB, userID uint, hash string, size, reserved int64) error {
+	remaining, err := s.remainingQuota(db, userID, reserved)
+	if err != nil {
+		return err
+	}
+	if remaining < 0 || size <= remaining {
+		return nil
+	}
+	if s.quotaAccounting != QuotaAccountingLogical {
+		// Content the user already holds costs nothing under deduplicated accounting.
+		var owned int64
+		userVersions(db, userID).
+			Joins("JOIN file_contents ON file_contents.id = file_versions.file_content_id").
+			Where("file_contents.sha256_hash = ?", hash).
+			Count(&owned)
+		if owned > 0 {
+			return nil
+		}
+	}
+	return ErrQuotaExceeded
+}
+
+// chargeQuota checks that content being saved for a user fits in their quota.
+// It locks the user's quota until tx ends, so uploads finishing at the same
+// time are checked one after the other instead of each against the usage
+// from before the others were saved.
+func (s *FileService) chargeQuota(tx *gorm.DB, userID uint, content *models.FileContent, reserved int64) error {
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?, ?)", quotaLockClass, userID).Error; err != nil {
+		return err
+	}
+	return s.checkQuota(tx, userID, content.SHA256Hash, content.FileSize, reserved)
+}
+
+// userVersions selects the versions of every file a user owns.
+func userVersions(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Model(&models.FileVersion{}).
+		Joins("JOIN files ON files.id = file_versions.file_id").
+		Where("files.user_id = ?", userID)
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"filevault-backend/internal/database"
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used; its session has been logged out")
+	ErrTokenRevoked        = errors.New("token has been revoked")
+)
+
+// TokenPair is what a client holds after logging in: a short-lived access
+// token for API calls and a refresh token to get the next pair with.
+type TokenPair struct {
+	AccessToken  string
+	ExpiresAt    time.Time // When the access token expires
+	RefreshToken string
+}
+
+func hashRefreshToken(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
+
+// IssueTokens starts a new session, and with it a new refresh token family,
+// for a user who just authenticated from the given client.
+func (s *AuthService) IssueTokens(user *models.User, userAgent, ipAddress string) (*TokenPair, error) {
+	var pair *TokenPair
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		session, err := s.sessionService.start(tx, user.ID, userAgent, ipAddress)
+		if err != nil {
+			return err
+		}
+		pair, err = s.issueTokens(tx, user, session.ID)
+		return err
+	})
+	return pair, err
+}
+
+func (s *AuthService) issueTokens(tx *gorm.DB, user *models.User, familyID string) (*TokenPair, error) {
+	refreshToken, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Create(&models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: hashRefreshToken(refreshToken),
+		ExpiresAt: time.Now().Add(s.refreshTTL),
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	accessToken, expiresAt, err := s.GenerateToken(user, familyID)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{AccessToken: accessToken, ExpiresAt: expiresAt, RefreshToken: refreshToken}, nil
+}
+
+// Refresh exchanges a refresh token for a new token pair in the same family.
+// Every refresh token can be exchanged once. Presenting one a second time
+// means it was copied, so the whole session is terminated, cutting off both
+// the legitimate client and whoever holds the copy.
+func (s *AuthService) Refresh(refreshToken string) (*TokenPair, *models.User, error) {
+	var token models.RefreshToken
+	err := database.DB.Where("token_hash = ?", hashRefreshToken(refreshToken)).First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if time.Now().After(token.ExpiresAt) {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+	if token.RotatedAt != nil {
+		s.reuseDetected(&token)
+		return nil, nil, ErrRefreshTokenReused
+	}
+
+	user, err := s.GetActiveUser(token.UserID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrInvalidRefreshToken
+		}
+		return nil, nil, err
+	}
+
+	var pair *TokenPair
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// Only one exchange of a token can win; a concurrent one is a reuse.
+		result := tx.Model(&token).Where("rotated_at IS NULL").Update("rotated_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRefreshTokenReused
+		}
+		// The session may have been terminated since the token was issued.
+		result = tx.Model(&models.Session{}).Where("id = ?", token.FamilyID).Update("last_seen_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidRefreshToken
+		}
+		pair, err = s.issueTokens(tx, user, token.FamilyID)
+		return err
+	})
+	if errors.Is(err, ErrRefreshTokenReused) {
+		s.reuseDetected(&token)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return pair, user, nil
+}
+
+func (s *AuthService) reuseDetected(token *models.RefreshToken) {
+	log.Printf("Refresh token reuse detected for user %d; terminating session %s", token.UserID, token.FamilyID)
+	if err := s.sessionService.Terminate(token.UserID, token.FamilyID); err != nil && !errors.Is(err, ErrSessionNotFound) {
+		log.Printf("Terminating session %s failed: %v", token.FamilyID, err)
+	}
+}
+
+// Logout terminates the session of a refresh token. Unknown tokens are
+// ignored, so logging out twice is harmless.
+func (s *AuthService) Logout(refreshToken string) error {
+	var token models.RefreshToken
+	err := database.DB.Where("token_hash = ?", hashRefreshToken(refreshToken)).First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = s.sessionService.Terminate(token.UserID, token.FamilyID)
+	if errors.Is(err, ErrSessionNotFound) {
+		return nil
+	}
+	return err
+}
+
+// CheckRevoked rejects access tokens whose session has been terminated, and
+// tokens issued before access tokens belonged to a session.
+func (s *AuthService) CheckRevoked(claims *Claims) error {
+	if claims.FamilyID == "" {
+		return ErrTokenRevoked
+	}
+	return s.sessionService.Touch(claims.FamilyID, claims.UserID)
+}
+
+// DeleteExpiredRefreshTokens removes refresh tokens that can no longer be
+// exchanged, which are kept until then so reuse can still be detected, and
+// the sessions left without any.
+func (s *AuthService) DeleteExpiredRefreshTokens() (int64, error) {
+	result := database.DB.Where("expires_at < ?", time.Now()).Delete(&models.RefreshToken{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if _, err := s.sessionService.deleteIdle(s.refreshTTL); err != nil {
+		return result.RowsAffected, err
+	}
+	return result.RowsAffected, nil
+}
+
+// StartTokenCleanup runs DeleteExpiredRefreshTokens every interval in the
+// background.
+func (s *AuthService) StartTokenCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for ; ; <-ticker.C {
+			deleted, err := s.DeleteExpiredRefreshTokens()
+			if err != nil {
+				log.Printf("Refresh token cleanup failed: %v", err)
+				continue
+			}
+			if deleted > 0 {
+				log.Printf("Deleted %d expired refresh token(s)", deleted)
+			}
+		}
+	}()
+}
@@ -0,0 +1,436 @@
+package services
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"filevault-backend/internal/database"
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUploadNotFound     = errors.New("upload not found")
+	ErrUploadOffset       = errors.New("upload offset does not match")
+	ErrUploadLocked       = errors.New("upload is being written by another request")
+	ErrUploadComplete     = errors.New("upload is already complete")
+	ErrChecksumAlgorithm  = errors.New("unsupported checksum algorithm")
+	ErrChecksumMismatch   = errors.New("checksum mismatch")
+	ErrUploadInvalidChunk = errors.New("chunk exceeds the declared upload length")
+	ErrUploadExpired      = errors.New("upload has expired")
+)
+
+// SupportedChecksumAlgorithms lists the tus checksum algorithms accepted on PATCH.
+var SupportedChecksumAlgorithms = []string{"sha1", "md5", "sha256"}
+
+// UploadService implements resumable uploads. Every appended chunk is written
+// as its own temporary object, which keeps it independent of whether the
+// storage backend can append, and the chunks are stitched together through
+// FileService.StoreContent once the upload is complete. The declared length
+// of an upload is reserved against the user's quota until it completes or
+// expires.
+type UploadService struct {
+	fileService    *FileService
+	storageService StorageService
+	expiry         time.Duration // How long an upload may go without receiving data
+
+	mu     sync.Mutex
+	active map[string]bool
+}
+
+func NewUploadService(fileService *FileService, storageService StorageService, expiry time.Duration) *UploadService {
+	return &UploadService{
+		fileService:    fileService,
+		storageService: storageService,
+		expiry:         expiry,
+		active:         make(map[string]bool),
+	}
+}
+
+// MaxSize is the largest upload length a client may declare. Uploads the
+// client encrypts itself are not scanned, so they may exceed it up to the
+// maximum file size.
+func (s *UploadService) MaxSize() int64 {
+	return s.fileService.maxUploadSize("")
+}
+
+// Create starts an upload. encryption is set when the client encrypts the
+// data itself, and is applied once the upload finishes.
+func (s *UploadService) Create(userID uint, folderID *uint, length int64, filename, mimeType, metadata string, encryption *models.ClientEncryption) (*models.UploadSession, error) {
+	filename, err := validFileName(filename)
+	if err != nil {
+		return nil, err
+	}
+	if max := s.fileService.maxUploadSize(mimeType); max > 0 && length > max {
+		return nil, ErrFileTooLarge
+	}
+	// The full length is reserved whatever the accounting mode, even if the
+	// content turns out to be a duplicate, so unfinished uploads cannot take
+	// up storage beyond the quota.
+	remaining, err := s.fileService.RemainingQuota(userID)
+	if err != nil {
+		return nil, err
+	}
+	if remaining >= 0 && length > remaining {
+		return nil, ErrQuotaExceeded
+	}
+	// Chunks wait in storage until the upload completes, so they are
+	// encrypted like any other blob.
+	_, keyID, wrappedKey, err := s.fileService.keyRing.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+	session := &models.UploadSession{
+		ID:         uuid.NewString(),
+		UserID:     userID,
+		FolderID:   folderID,
+		Filename:   filename,
+		MimeType:   mimeType,
+		Length:     length,
+		Metadata:   metadata,
+		KeyID:      keyID,
+		WrappedKey: wrappedKey,
+		ExpiresAt:  s.expiresAt(),
+	}
+	if encryption != nil {
+		session.Encrypted = true
+		session.ClientWrappedKey = encryption.WrappedKey
+		session.ClientMetadata = encryption.Metadata
+	}
+	if err := database.DB.Create(session).Error; err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (s *UploadService) Get(id string, userID uint) (*models.UploadSession, error) {
+	var session models.UploadSession
+	err := database.DB.Where("id = ? AND user_id = ?", id, userID).First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if session.FileID == nil && session.ExpiresAt != nil && time.Now().After(*session.ExpiresAt) {
+		return nil, ErrUploadExpired
+	}
+	return &session, nil
+}
+
+// Append writes the request body at the given offset and returns the new
+// offset. Without a checksum, whatever arrived before a dropped connection is
+// kept so the client can resume from there; with a checksum the chunk is
+// all-or-nothing.
+func (s *UploadService) Append(session *models.UploadSession, offset int64, body io.Reader, checksum string) (int64, error) {
+	if !s.lock(session.ID) {
+		return 0, ErrUploadLocked
+	}
+	defer s.unlock(session.ID)
+
+	// Re-read under the lock; a concurrent request may have moved the offset.
+	if err := database.DB.First(session, "id = ?", session.ID).Error; err != nil {
+		return 0, err
+	}
+	if session.FileID != nil {
+		return 0, ErrUploadComplete
+	}
+	if offset != session.Offset {
+		return 0, ErrUploadOffset
+	}
+	if session.Offset == 0 {
+		var err error
+		if body, err = s.checkHead(session, body); err != nil {
+			return 0, err
+		}
+	}
+
+	var verifier hash.Hash
+	var expected []byte
+	if checksum != "" {
+		var err error
+		if verifier, expected, err = parseUploadChecksum(checksum); err != nil {
+			return 0, err
+		}
+	}
+
+	remaining := session.Length - session.Offset
+	reader := io.Reader(&partialReader{r: io.LimitReader(body, remaining+1)})
+	if verifier != nil {
+		reader = io.TeeReader(reader, verifier)
+	}
+
+	dataKey, err := s.fileService.keyRing.DataKey(session.KeyID, session.WrappedKey)
+	if err != nil {
+		return 0, err
+	}
+	key := s.chunkKey(session.ID, session.Offset)
+	written, err := putBlob(s.storageService, key, reader, dataKey)
+	if err != nil {
+		s.storageService.Delete(key)
+		return 0, err
+	}
+	if written > remaining {
+		s.storageService.Delete(key)
+		return 0, ErrUploadInvalidChunk
+	}
+	if verifier != nil && string(verifier.Sum(nil)) != string(expected) {
+		s.storageService.Delete(key)
+		return 0, ErrChecksumMismatch
+	}
+	if written == 0 {
+		s.storageService.Delete(key)
+		return session.Offset, nil
+	}
+
+	session.Offset += written
+	session.ExpiresAt = s.expiresAt()
+	if err := database.DB.Model(session).Updates(map[string]interface{}{
+		"mime_type":     session.MimeType,
+		"upload_offset": session.Offset,
+		"expires_at":    session.ExpiresAt,
+	}).Error; err != nil {
+		s.storageService.Delete(key)
+		return 0, err
+	}
+	return session.Offset, nil
+}
+
+// checkHead sniffs the start of the first chunk, so content that does not
+// match its declared type is turned away before the rest is sent. An upload
+// created without a type takes the sniffed one. Finish checks the assembled
+// content again.
+func (s *UploadService) checkHead(session *models.UploadSession, body io.Reader) (io.Reader, error) {
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(&partialReader{r: body}, head)
+	head = head[:n]
+	if n == 0 {
+		return body, nil
+	}
+	if session.MimeType == "" {
+		session.MimeType = http.DetectContentType(head)
+	} else if err := utils.ValidateMimeType(session.MimeType, head); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidFileType, err.Error())
+	}
+	return io.MultiReader(bytes.NewReader(head), body), nil
+}
+
+// IsComplete reports whether every declared byte has been received.
+func (s *UploadService) IsComplete(session *models.UploadSession) bool {
+	return session.Offset == session.Length
+}
+
+// Finish assembles the chunks of a fully received upload, stores the content
+// with the regular deduplication rules and saves it like a regular upload,
+// either as a new file or as the next version of an existing one.
+func (s *UploadService) Finish(session *models.UploadSession) (*models.File, error) {
+	if !s.lock(session.ID) {
+		return nil, ErrUploadLocked
+	}
+	defer s.unlock(session.ID)
+
+	chunks, err := s.storageService.List(s.chunkPrefix(session.ID))
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := s.fileService.keyRing.DataKey(session.KeyID, session.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	// The upload may use the quota it reserved. From here on the content
+	// holds a reference, which SaveUpload releases if it fails.
+	reader := &chunkReader{storage: s.storageService, chunks: chunks, dataKey: dataKey}
+	content, err := s.fileService.storeContent(session.UserID, reader, session.MimeType, session.Length)
+	if err != nil {
+		return nil, err
+	}
+
+	var encryption *models.ClientEncryption
+	if session.Encrypted {
+		encryption = &models.ClientEncryption{WrappedKey: session.ClientWrappedKey, Metadata: session.ClientMetadata}
+	}
+	file, _, err := s.fileService.saveUpload(session.UserID, session.FolderID, session.Filename, content, encryption, session.Length)
+	if err != nil {
+		return nil, err
+	}
+
+	s.deleteChunks(session.ID)
+	database.DB.Model(session).Update("file_id", file.ID)
+	session.FileID = &file.ID
+	return file, nil
+}
+
+// Terminate discards an upload and everything received for it so far.
+func (s *UploadService) Terminate(session *models.UploadSession) error {
+	if !s.lock(session.ID) {
+		return ErrUploadLocked
+	}
+	defer s.unlock(session.ID)
+
+	s.deleteChunks(session.ID)
+	return database.DB.Delete(session).Error
+}
+
+// DeleteExpired discards unfinished uploads that have expired, along with
+// their chunks, and forgets finished ones once their expiry has passed.
+func (s *UploadService) DeleteExpired() (int, error) {
+	var sessions []models.UploadSession
+	if err := database.DB.Where("expires_at IS NULL OR expires_at < ?", time.Now()).Find(&sessions).Error; err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for i := range sessions {
+		err := s.Terminate(&sessions[i])
+		if errors.Is(err, ErrUploadLocked) {
+			continue // Data is arriving right now
+		}
+		if err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// StartCleanup runs DeleteExpired every interval in the background.
+func (s *UploadService) StartCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for ; ; <-ticker.C {
+			deleted, err := s.DeleteExpired()
+			if err != nil {
+				log.Printf("Upload cleanup failed: %v", err)
+				continue
+			}
+			if deleted > 0 {
+				log.Printf("Discarded %d expired upload(s)", deleted)
+			}
+		}
+	}()
+}
+
+func (s *UploadService) expiresAt() *time.Time {
+	expiresAt := time.Now().Add(s.expiry)
+	return &expiresAt
+}
+
+func (s *UploadService) chunkPrefix(id string) string {
+	return TempObjectPrefix + "tus-" + id + "-"
+}
+
+// chunkKey zero-pads the offset so chunks list in upload order.
+func (s *UploadService) chunkKey(id string, offset int64) string {
+	return fmt.Sprintf("%s%020d", s.chunkPrefix(id), offset)
+}
+
+func (s *UploadService) deleteChunks(id string) {
+	chunks, err := s.storageService.List(s.chunkPrefix(id))
+	if err != nil {
+		return
+	}
+	for _, chunk := range chunks {
+		s.storageService.Delete(chunk.Key)
+	}
+}
+
+func (s *UploadService) lock(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active[id] {
+		return false
+	}
+	s.active[id] = true
+	return true
+}
+
+func (s *UploadService) unlock(id string) {
+	s.mu.Lock()
+	delete(s.active, id)
+	s.mu.Unlock()
+}
+
+// parseUploadChecksum parses an Upload-Checksum header ("<algorithm> <base64 digest>").
+func parseUploadChecksum(header string) (hash.Hash, []byte, error) {
+	algorithm, encoded, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok {
+		return nil, nil, ErrChecksumAlgorithm
+	}
+	expected, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, nil, ErrChecksumMismatch
+	}
+	switch strings.ToLower(algorithm) {
+	case "sha1":
+		return sha1.New(), expected, nil
+	case "md5":
+		return md5.New(), expected, nil
+	case "sha256":
+		return sha256.New(), expected, nil
+	}
+	return nil, nil, ErrChecksumAlgorithm
+}
+
+// partialReader turns a read error into EOF so a chunk cut short by a
+// dropped connection is still stored up to the last byte received.
+type partialReader struct {
+	r io.Reader
+}
+
+func (p *partialReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if err != nil && err != io.EOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// chunkReader reads a list of stored chunks back to back.
+type chunkReader struct {
+	storage StorageService
+	chunks  []ObjectInfo
+	dataKey []byte // Key the chunks are encrypted with, if any
+	current io.ReadCloser
+}
+
+func (r *chunkReader) Read(b []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.chunks) == 0 {
+				return 0, io.EOF
+			}
+			next, err := openBlob(r.storage, r.chunks[0].Key, r.dataKey)
+			if err != nil {
+				return 0, err
+			}
+			r.current = next
+			r.chunks = r.chunks[1:]
+		}
+		n, err := r.current.Read(b)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"filevault-backend/internal/config"
+)
+
+// ScanResult is the verdict of a malware scan.
+type ScanResult struct {
+	Infected  bool
+	Signature string // Name of the malware found, if any
+}
+
+// Scanner checks a stream for malware.
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (*ScanResult, error)
+	// MaxSize is the longest stream Scan accepts, or 0 if there is no limit.
+	MaxSize() int64
+}
+
+// What downloads do with content whose scan failed for good.
+const (
+	ScanFailureBlock = "block"
+	ScanFailureAllow = "allow"
+)
+
+var ErrScanTooLarge = errors.New("stream exceeds the scanner's maximum size")
+
+// ValidateScanFailurePolicy rejects unknown policies, which would otherwise
+// decide silently whether unscanned files can be downloaded.
+func ValidateScanFailurePolicy(policy string) error {
+	switch policy {
+	case ScanFailureBlock, ScanFailureAllow:
+		return nil
+	}
+	return fmt.Errorf("unknown scan failure policy %q; use %q or %q", policy, ScanFailureBlock, ScanFailureAllow)
+}
+
+// NewScanner builds the malware scanner configured by cfg.ClamdAddress, or
+// returns nil when scanning is disabled.
+func NewScanner(cfg *config.Config) (Scanner, error) {
+	if cfg.ClamdAddress == "" {
+		return nil, nil
+	}
+	return NewClamdScanner(cfg.ClamdAddress, cfg.ClamdMaxStream)
+}
+
+// clamdChunkSize is the size of the chunks a stream is sent to clamd in.
+const clamdChunkSize = 64 << 10
+
+// ClamdScanner scans streams with a clamd daemon using its INSTREAM command,
+// so the daemon does not need access to the storage backend.
+type ClamdScanner struct {
+	network     string
+	address     string
+	maxStream   int64
+	dialTimeout time.Duration
+}
+
+// NewClamdScanner connects to clamd at address, either "unix:///path/to/clamd.sock"
+// or "tcp://host:port" (a bare "host:port" also means TCP). maxStream is
+// clamd's StreamMaxLength; 0 leaves enforcing it to clamd.
+func NewClamdScanner(address string, maxStream int64) (*ClamdScanner, error) {
+	scanner := &ClamdScanner{network: "tcp", address: address, maxStream: maxStream, dialTimeout: 10 * time.Second}
+	switch {
+	case strings.HasPrefix(address, "unix://"):
+		scanner.network, scanner.address = "unix", strings.TrimPrefix(address, "unix://")
+	case strings.HasPrefix(address, "tcp://"):
+		scanner.address = strings.TrimPrefix(address, "tcp://")
+	}
+	if scanner.address == "" {
+		return nil, fmt.Errorf("invalid clamd address %q", address)
+	}
+	return scanner, nil
+}
+
+// MaxSize is clamd's StreamMaxLength, as configured.
+func (s *ClamdScanner) MaxSize() int64 {
+	return s.maxStream
+}
+
+// Scan streams r to clamd and parses its verdict. A verdict clamd reports as
+// an error, such as the stream exceeding its StreamMaxLength, is returned as
+// a permanent error since sending the same data again will not help.
+func (s *ClamdScanner) Scan(ctx context.Context, r io.Reader) (*ScanResult, error) {
+	dialer := net.Dialer{Timeout: s.dialTimeout}
+	conn, err := dialer.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	return instream(conn, r)
+}
+
+// instream sends r over conn with clamd's INSTREAM command, as chunks each
+// preceded by their length, and reads the reply.
+func instream(conn net.Conn, r io.Reader) (*ScanResult, error) {
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return nil, fmt.Errorf("sending to clamd: %w", err)
+	}
+	chunk := make([]byte, clamdChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, readErr := r.Read(chunk)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return nil, fmt.Errorf("sending to clamd: %w", err)
+			}
+			if _, err := conn.Write(chunk[:n]); err != nil {
+				// clamd hangs up once the stream exceeds its limit; its reply says so.
+				break
+			}
+		}
+		if errors.Is(readErr, io.EOF) {
+			break
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+	// A zero-length chunk ends the stream.
+	binary.BigEndian.PutUint32(size, 0)
+	conn.Write(size)
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return nil, fmt.Errorf("reading clamd reply: %w", err)
+	}
+	return parseClamdReply(reply)
+}
+
+// parseClamdReply parses replies of the form "stream: OK",
+// "stream: <signature> FOUND" and "<message> ERROR".
+func parseClamdReply(reply string) (*ScanResult, error) {
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	verdict := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case verdict == "OK":
+		return &ScanResult{}, nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return &ScanResult{Infected: true, Signature: strings.TrimSuffix(verdict, " FOUND")}, nil
+	case strings.HasSuffix(verdict, " ERROR"):
+		return nil, permanent(fmt.Errorf("clamd: %s", strings.TrimSuffix(verdict, " ERROR")))
+	default:
+		return nil, fmt.Errorf("unexpected clamd reply %q", reply)
+	}
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestParseClamdReply(t *testing.T) {
+	tests := []struct {
+		reply     string
+		want      *ScanResult
+		permanent bool // Expect a permanent error rather than a result
+		retryable bool // Expect an error that may be retried
+	}{
+		{reply: "stream: OK\x00", want: &ScanResult{}},
+		{reply: "stream: OK\n", want: &ScanResult{}},
+		{reply: "stream: Eicar-Test-Signature FOUND\x00", want: &ScanResult{Infected: true, Signature: "Eicar-Test-Signature"}},
+		{reply: "stream: Win.Test.EICAR_HDB-1 FOUND", want: &ScanResult{Infected: true, Signature: "Win.Test.EICAR_HDB-1"}},
+		{reply: "INSTREAM size limit exceeded. ERROR\x00", permanent: true},
+		{reply: "stream: Can't allocate memory ERROR", permanent: true},
+		{reply: "UNKNOWN COMMAND\x00", retryable: true},
+		{reply: "", retryable: true},
+	}
+	for _, tt := range tests {
+		got, err := parseClamdReply(tt.reply)
+		var permanentErr permanentError
+		switch {
+		case tt.permanent:
+			if !errors.As(err, &permanentErr) {
+				t.Errorf("parseClamdReply(%q) = %+v, %v; want a permanent error", tt.reply, got, err)
+			}
+		case tt.retryable:
+			if err == nil || errors.As(err, &permanentErr) {
+				t.Errorf("parseClamdReply(%q) = %+v, %v; want a retryable error", tt.reply, got, err)
+			}
+		case err != nil:
+			t.Errorf("parseClamdReply(%q) returned error %v", tt.reply, err)
+		case *got != *tt.want:
+			t.Errorf("parseClamdReply(%q) = %+v, want %+v", tt.reply, got, tt.want)
+		}
+	}
+}
+
+// fakeClamd reads one INSTREAM command from conn, sends reply and returns
+// the chunk sizes and data it received.
+func fakeClamd(t *testing.T, conn net.Conn, reply string) (sizes []int, data []byte, err error) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	command, err := r.ReadString(0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if command != "zINSTREAM\x00" {
+		t.Errorf("command = %q, want %q", command, "zINSTREAM\x00")
+	}
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return nil, nil, err
+		}
+		if size == 0 {
+			break
+		}
+		chunk := make([]byte, size)
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return nil, nil, err
+		}
+		sizes = append(sizes, int(size))
+		data = append(data, chunk...)
+	}
+	_, err = conn.Write([]byte(reply))
+	return sizes, data, err
+}
+
+func TestInstream(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789abcdef"), (2*clamdChunkSize+100)/16)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		reply    string
+		infected bool
+		wantErr  bool
+	}{
+		{name: "Clean", data: payload, reply: "stream: OK\x00"},
+		{name: "Infected", data: []byte("X5O!P%@AP"), reply: "stream: Eicar-Test-Signature FOUND\x00", infected: true},
+		{name: "Error", data: payload, reply: "INSTREAM size limit exceeded. ERROR\x00", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			type received struct {
+				sizes []int
+				data  []byte
+				err   error
+			}
+			done := make(chan received, 1)
+			go func() {
+				sizes, data, err := fakeClamd(t, server, tt.reply)
+				done <- received{sizes, data, err}
+			}()
+
+			result, err := instream(client, bytes.NewReader(tt.data))
+			client.Close()
+			got := <-done
+			if got.err != nil {
+				t.Fatalf("fake clamd: %v", got.err)
+			}
+
+			if !bytes.Equal(got.data, tt.data) {
+				t.Errorf("clamd received %d bytes, want the %d sent", len(got.data), len(tt.data))
+			}
+			for i, size := range got.sizes {
+				if size > clamdChunkSize || (i < len(got.sizes)-1 && size != clamdChunkSize) {
+					t.Errorf("chunk sizes %v, want full %d byte chunks and a shorter last one", got.sizes, clamdChunkSize)
+					break
+				}
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("instream = %+v, want an error", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Infected != tt.infected {
+				t.Errorf("Infected = %v, want %v", result.Infected, tt.infected)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"html"
+	"strings"
+
+	"filevault-backend/internal/database"
+	"filevault-backend/internal/models"
+)
+
+// Full-text search expressions over the generated name_vector and
+// text_vector columns (see database.Migrate) and the file's tags. Names and
+// tags are matched word for word; document text is stemmed as English, so
+// "contracts" finds "contract". They expect the query as the named arg @q.
+const (
+	nameQuery = "websearch_to_tsquery('simple', @q)"
+	textQuery = "websearch_to_tsquery('english', @q)"
+	tagVector = "to_tsvector('simple', coalesce((SELECT string_agg(tags.name, ' ') FROM file_tags " +
+		"JOIN tags ON tags.id = file_tags.tag_id WHERE file_tags.file_id = files.id), ''))"
+
+	fullTextMatch = "(files.name_vector @@ " + nameQuery +
+		" OR " + tagVector + " @@ " + nameQuery +
+		" OR file_contents.text_vector @@ " + textQuery + ")"
+
+	// A hit in the name outweighs one in the tags, which outweighs one in
+	// the text.
+	fullTextRank = "(ts_rank(files.name_vector, " + nameQuery + ") * 4" +
+		" + ts_rank(" + tagVector + ", " + nameQuery + ") * 2" +
+		" + ts_rank(file_contents.text_vector, " + textQuery + "))"
+
+	// ts_headline copies the document text as is, so matches are marked
+	// with sentinels and the excerpt is escaped before they become <mark>
+	// tags (see highlightHTML).
+	fullTextHighlight = "COALESCE(CASE WHEN file_contents.text_vector @@ " + textQuery +
+		" THEN ts_headline('english', file_contents.extracted_text, " + textQuery +
+		", 'MaxFragments=2, MaxWords=20, MinWords=5, StartSel=" + highlightStart + ", StopSel=" + highlightStop + "') END, '')"
+)
+
+// Private use characters, which extracted text has no business containing.
+const (
+	highlightStart = "\ue000"
+	highlightStop  = "\ue001"
+)
+
+var highlightMarks = strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>")
+
+// highlightHTML turns an excerpt from fullTextHighlight into HTML, with the
+// matches wrapped in <mark>.
+func highlightHTML(excerpt string) string {
+	return highlightMarks.Replace(html.EscapeString(excerpt))
+}
+
+// searchHit is one row of a file listing page before the files are loaded.
+type searchHit struct {
+	ID        uint
+	Rank      float64 `gorm:"column:search_rank"`
+	Highlight string
+}
+
+// loadHits loads the files of a page with their associations, in page order.
+func loadHits(hits []searchHit) ([]*models.File, error) {
+	ids := make([]uint, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.ID
+	}
+
+	var loaded []*models.File
+	if len(ids) > 0 {
+		if err := database.DB.Preload("Content").Preload("User").Preload("Tags").
+			Where("id IN ?", ids).Find(&loaded).Error; err != nil {
+			return nil, err
+		}
+	}
+	byID := make(map[uint]*models.File, len(loaded))
+	for _, file := range loaded {
+		byID[file.ID] = file
+	}
+
+	files := make([]*models.File, 0, len(hits))
+	for _, hit := range hits {
+		if file, ok := byID[hit.ID]; ok {
+			file.Rank = hit.Rank
+			file.Highlight = highlightHTML(hit.Highlight)
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
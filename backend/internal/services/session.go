@@ -0,0 +1,116 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"filevault-backend/internal/database"
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// sessionTouchInterval is how stale a session's last-seen time may get before
+// a request updates it, so busy clients do not write on every request.
+const sessionTouchInterval = time.Minute
+
+// SessionService tracks where users are logged in. Every login creates a
+// session, and terminating one revokes the refresh and access tokens issued
+// to it.
+type SessionService struct{}
+
+func NewSessionService() *SessionService {
+	return &SessionService{}
+}
+
+func (s *SessionService) start(tx *gorm.DB, userID uint, userAgent, ipAddress string) (*models.Session, error) {
+	session := &models.Session{
+		ID:         uuid.NewString(),
+		UserID:     userID,
+		UserAgent:  userAgent,
+		IPAddress:  ipAddress,
+		LastSeenAt: time.Now(),
+	}
+	if err := tx.Create(session).Error; err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Touch checks that a session still exists and belongs to userID, and
+// records that it was just used. Tokens of terminated sessions fail with
+// ErrTokenRevoked.
+func (s *SessionService) Touch(sessionID string, userID uint) error {
+	var session models.Session
+	err := database.DB.Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrTokenRevoked
+	}
+	if err != nil {
+		return err
+	}
+	if time.Since(session.LastSeenAt) < sessionTouchInterval {
+		return nil
+	}
+	return database.DB.Model(&session).Update("last_seen_at", time.Now()).Error
+}
+
+// List returns a user's sessions, most recently used first.
+func (s *SessionService) List(userID uint) ([]models.Session, error) {
+	var sessions []models.Session
+	err := database.DB.Where("user_id = ?", userID).Order("last_seen_at DESC").Find(&sessions).Error
+	return sessions, err
+}
+
+// Terminate logs one of a user's sessions out.
+func (s *SessionService) Terminate(userID uint, sessionID string) error {
+	deleted, err := s.terminate(database.DB.Where("id = ? AND user_id = ?", sessionID, userID))
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// TerminateAll logs a user out everywhere, except for the session with the
+// ID keep if it is not empty. It returns the number of sessions terminated.
+func (s *SessionService) TerminateAll(userID uint, keep string) (int64, error) {
+	query := database.DB.Where("user_id = ?", userID)
+	if keep != "" {
+		query = query.Where("id <> ?", keep)
+	}
+	return s.terminate(query)
+}
+
+// terminate deletes the sessions matched by query together with their
+// refresh tokens.
+func (s *SessionService) terminate(query *gorm.DB) (int64, error) {
+	var ids []string
+	if err := query.Model(&models.Session{}).Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("family_id IN ?", ids).Delete(&models.RefreshToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id IN ?", ids).Delete(&models.Session{}).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(ids)), nil
+}
+
+// deleteIdle deletes sessions unused for longer than idle. They have no
+// refresh token left that could still be exchanged.
+func (s *SessionService) deleteIdle(idle time.Duration) (int64, error) {
+	return s.terminate(database.DB.Where("last_seen_at < ?", time.Now().Add(-idle)))
+}
@@ -0,0 +1,277 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"filevault-backend/internal/database"
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrShareNotFound         = errors.New("share link not found")
+	ErrShareExpired          = errors.New("share link has expired")
+	ErrShareExhausted        = errors.New("share link has reached its download limit")
+	ErrSharePasswordRequired = errors.New("share link requires a password")
+	ErrSharePasswordInvalid  = errors.New("incorrect share link password")
+	ErrShareWithSelf         = errors.New("cannot share a file with its owner")
+	ErrNotGrantor            = errors.New("only the file owner can change a share someone else created")
+)
+
+// ShareLinkOptions are the optional restrictions placed on a share link.
+type ShareLinkOptions struct {
+	ExpiresAt    *time.Time
+	Password     string
+	MaxDownloads *int
+}
+
+// ShareService manages file shares: public share links, each identified by a
+// random token so links cannot be guessed from file IDs, and direct shares
+// with other registered users.
+type ShareService struct {
+	authService *AuthService
+}
+
+func NewShareService(authService *AuthService) *ShareService {
+	return &ShareService{
+		authService: authService,
+	}
+}
+
+func (s *ShareService) CreateLink(file *models.File, createdBy uint, opts ShareLinkOptions) (*models.FileShare, error) {
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	share := &models.FileShare{
+		FileID:       file.ID,
+		UserID:       createdBy,
+		Permissions:  models.PermissionDownload,
+		Token:        &token,
+		ExpiresAt:    opts.ExpiresAt,
+		MaxDownloads: opts.MaxDownloads,
+	}
+	if opts.Password != "" {
+		// Link passwords are hashed exactly like account passwords.
+		if share.PasswordHash, err = s.authService.HashPassword(opts.Password); err != nil {
+			return nil, err
+		}
+		share.HasPassword = true
+	}
+	if err := database.DB.Create(share).Error; err != nil {
+		return nil, err
+	}
+	return share, nil
+}
+
+// ListLinks returns the share links of a file, including expired ones so the
+// owner can see and clean them up.
+func (s *ShareService) ListLinks(fileID uint) ([]models.FileShare, error) {
+	var shares []models.FileShare
+	err := database.DB.Where("file_id = ? AND token IS NOT NULL", fileID).
+		Order("created_at DESC").Find(&shares).Error
+	return shares, err
+}
+
+// RevokeLink deletes a share link. When grantedBy is set, only a link that
+// user created can be revoked.
+func (s *ShareService) RevokeLink(fileID, shareID uint, grantedBy *uint) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		var share models.FileShare
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND file_id = ? AND token IS NOT NULL", shareID, fileID).First(&share).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrShareNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if grantedBy != nil && share.UserID != *grantedBy {
+			return ErrNotGrantor
+		}
+		return tx.Delete(&share).Error
+	})
+}
+
+// ResolveLink looks up a share link by token, together with the shared file
+// and its content, and checks every restriction placed on the link.
+func (s *ShareService) ResolveLink(token, password string) (*models.FileShare, error) {
+	var share models.FileShare
+	err := database.DB.Preload("File").Preload("File.Content").
+		Where("token = ?", token).First(&share).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && share.File == nil) {
+		return nil, ErrShareNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if share.IsExpired() {
+		return nil, ErrShareExpired
+	}
+	if share.IsExhausted() {
+		return nil, ErrShareExhausted
+	}
+	if share.PasswordHash != "" {
+		if password == "" {
+			return nil, ErrSharePasswordRequired
+		}
+		if err := s.authService.CheckPassword(password, share.PasswordHash); err != nil {
+			return nil, ErrSharePasswordInvalid
+		}
+	}
+	return &share, nil
+}
+
+// ConsumeDownload counts one download against a share link. The check and the
+// increment happen in a single statement, so concurrent downloads cannot push
+// a limited link past its maximum.
+func (s *ShareService) ConsumeDownload(share *models.FileShare) error {
+	result := database.DB.Model(&models.FileShare{}).
+		Where("id = ? AND (max_downloads IS NULL OR download_count < max_downloads)", share.ID).
+		Update("download_count", gorm.Expr("download_count + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrShareExhausted
+	}
+	share.DownloadCount++
+	return nil
+}
+
+// RefundDownload returns a download ConsumeDownload reserved for a request
+// that ended up sending nothing.
+func (s *ShareService) RefundDownload(share *models.FileShare) error {
+	err := database.DB.Model(&models.FileShare{}).
+		Where("id = ? AND download_count > 0", share.ID).
+		Update("download_count", gorm.Expr("download_count - 1")).Error
+	if err != nil {
+		return err
+	}
+	share.DownloadCount--
+	return nil
+}
+
+// ShareWithUser grants a registered user the given permissions on a file.
+// Sharing again with the same user replaces the permissions and expiry of the
+// existing grant, which only its creator or the file owner (ownerAccess) may
+// do. Encrypted files also need the file key wrapped with the recipient's
+// public key, which is stored as the recipient's copy.
+func (s *ShareService) ShareWithUser(file *models.File, sharedBy uint, ownerAccess bool, identifier string, perms models.Permission, expiresAt *time.Time, wrappedKey string) (*models.FileShare, error) {
+	var recipient models.User
+	err := database.DB.Where("username = ? OR email = ?", identifier, identifier).First(&recipient).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if recipient.ID == file.UserID || recipient.ID == sharedBy {
+		return nil, ErrShareWithSelf
+	}
+	if file.Encrypted {
+		if recipient.PublicKey == "" {
+			return nil, ErrNoPublicKey
+		}
+		if wrappedKey == "" {
+			return nil, ErrWrappedKeyRequired
+		}
+	}
+
+	var share models.FileShare
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("file_id = ? AND share_with = ?", file.ID, recipient.ID).First(&share).Error
+		if err == nil {
+			if !ownerAccess && share.UserID != sharedBy {
+				return ErrNotGrantor
+			}
+			if err := tx.Model(&share).Updates(map[string]interface{}{
+				"permissions": perms,
+				"expires_at":  expiresAt,
+			}).Error; err != nil {
+				return err
+			}
+			share.Permissions = perms
+			share.ExpiresAt = expiresAt
+		} else if errors.Is(err, gorm.ErrRecordNotFound) {
+			share = models.FileShare{
+				FileID:      file.ID,
+				UserID:      sharedBy,
+				ShareWith:   &recipient.ID,
+				Permissions: perms,
+				ExpiresAt:   expiresAt,
+			}
+			if err := tx.Create(&share).Error; err != nil {
+				return err
+			}
+		} else {
+			return err
+		}
+
+		if !file.Encrypted {
+			return nil
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "file_id"}, {Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"wrapped_key", "updated_at"}),
+		}).Create(&models.FileKey{FileID: file.ID, UserID: recipient.ID, WrappedKey: wrappedKey}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	share.SharedWith = &recipient
+	share.Grants = perms.Names()
+	return &share, nil
+}
+
+// ListUserShares returns the users a file has been shared with directly.
+func (s *ShareService) ListUserShares(fileID uint) ([]models.FileShare, error) {
+	var shares []models.FileShare
+	err := database.DB.Preload("SharedWith").
+		Where("file_id = ? AND share_with IS NOT NULL", fileID).
+		Order("created_at DESC").Find(&shares).Error
+	return shares, err
+}
+
+// RevokeUserShare removes a user's access to a file, along with their copy of
+// its key if it is encrypted. When grantedBy is set, only a grant that user
+// created can be revoked. A recipient who kept the unwrapped key can still
+// decrypt copies they downloaded earlier.
+func (s *ShareService) RevokeUserShare(fileID, recipientID uint, grantedBy *uint) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		var share models.FileShare
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("file_id = ? AND share_with = ?", fileID, recipientID).First(&share).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrShareNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if grantedBy != nil && share.UserID != *grantedBy {
+			return ErrNotGrantor
+		}
+		if err := tx.Delete(&share).Error; err != nil {
+			return err
+		}
+		return tx.Where("file_id = ? AND user_id = ?", fileID, recipientID).Delete(&models.FileKey{}).Error
+	})
+}
+
+// SharedWithUser lists the files other users have shared with userID.
+func (s *ShareService) SharedWithUser(userID uint) ([]*models.File, error) {
+	var files []*models.File
+	err := database.DB.Preload("Content").Preload("User").
+		Joins("JOIN file_shares ON file_shares.file_id = files.id AND file_shares.deleted_at IS NULL").
+		Where("file_shares.share_with = ?", userID).
+		Where("file_shares.expires_at IS NULL OR file_shares.expires_at > ?", time.Now()).
+		Order("file_shares.created_at DESC").
+		Find(&files).Error
+	return files, err
+}
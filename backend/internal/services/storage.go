@@ -0,0 +1,164 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"filevault-backend/internal/config"
+)
+
+// ErrObjectNotFound is returned by storage drivers when a key does not exist.
+var ErrObjectNotFound = errors.New("object not found in storage")
+
+// ObjectInfo describes a single object held by a storage driver.
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// StorageService is the contract every blob storage driver implements.
+// Objects are addressed by an opaque key (the content SHA-256 for file blobs).
+type StorageService interface {
+	Put(key string, r io.Reader) (int64, error)
+	Open(key string) (io.ReadSeekCloser, error)
+	Stat(key string) (*ObjectInfo, error)
+	Delete(key string) error
+	List(prefix string) ([]ObjectInfo, error)
+	// Move renames an object, replacing any existing object at dst.
+	Move(src, dst string) error
+}
+
+// NewStorageService builds the storage driver selected by cfg.StorageDriver.
+func NewStorageService(cfg *config.Config) (StorageService, error) {
+	switch cfg.StorageDriver {
+	case "", "local":
+		return NewLocalStorage(cfg.UploadPath)
+	case "s3":
+		return NewS3Storage(cfg)
+	case "memory":
+		return NewMemoryStorage(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.StorageDriver)
+	}
+}
+
+// LocalStorage keeps objects as plain files under a single directory.
+type LocalStorage struct {
+	UploadPath string
+}
+
+func NewLocalStorage(uploadPath string) (*LocalStorage, error) {
+	if err := os.MkdirAll(uploadPath, 0755); err != nil {
+		return nil, err
+	}
+	return &LocalStorage{UploadPath: uploadPath}, nil
+}
+
+func (s *LocalStorage) path(key string) (string, error) {
+	if key == "" || strings.ContainsAny(key, `/\`) || key == "." || key == ".." {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	return filepath.Join(s.UploadPath, key), nil
+}
+
+func (s *LocalStorage) Put(key string, r io.Reader) (int64, error) {
+	filePath, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+	dst, err := os.Create(filePath)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(dst, r)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(filePath)
+		return 0, err
+	}
+	return n, nil
+}
+
+func (s *LocalStorage) Open(key string) (io.ReadSeekCloser, error) {
+	filePath, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrObjectNotFound
+	}
+	return f, err
+}
+
+func (s *LocalStorage) Stat(key string) (*ObjectInfo, error) {
+	filePath, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{Key: key, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+func (s *LocalStorage) Delete(key string) error {
+	filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrObjectNotFound
+	}
+	return err
+}
+
+func (s *LocalStorage) Move(src, dst string) error {
+	srcPath, err := s.path(src)
+	if err != nil {
+		return err
+	}
+	dstPath, err := s.path(dst)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(srcPath, dstPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrObjectNotFound
+	}
+	return err
+}
+
+func (s *LocalStorage) List(prefix string) ([]ObjectInfo, error) {
+	entries, err := os.ReadDir(s.UploadPath)
+	if err != nil {
+		return nil, err
+	}
+	var objects []ObjectInfo
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		fi, err := entry.Info()
+		if err != nil {
+			continue // Removed between ReadDir and Info
+		}
+		objects = append(objects, ObjectInfo{Key: entry.Name(), Size: fi.Size(), ModTime: fi.ModTime()})
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"filevault-backend/internal/config"
+)
+
+// s3PartSize is the part size streamed uploads are split into. The client
+// buffers a whole part in memory, and without a size it picks one big
+// enough for a 5TiB object.
+const s3PartSize = 16 << 20
+
+// s3MaxCopySize is the largest object a single CopyObject call can copy.
+const s3MaxCopySize = 5 << 30
+
+// S3Storage stores objects in an S3-compatible bucket (AWS S3, MinIO, ...).
+type S3Storage struct {
+	client *minio.Client
+	bucket string
+}
+
+func NewS3Storage(cfg *config.Config) (*S3Storage, error) {
+	if cfg.S3Endpoint == "" || cfg.S3Bucket == "" {
+		return nil, errors.New("S3_ENDPOINT and S3_BUCKET are required for the s3 storage driver")
+	}
+
+	lookup := minio.BucketLookupAuto
+	if cfg.S3ForcePathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(cfg.S3Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(cfg.S3AccessKey, cfg.S3SecretKey, ""),
+		Secure:       cfg.S3UseSSL,
+		Region:       cfg.S3Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	exists, err := client.BucketExists(ctx, cfg.S3Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("could not reach bucket %q: %w", cfg.S3Bucket, err)
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, cfg.S3Bucket, minio.MakeBucketOptions{Region: cfg.S3Region}); err != nil {
+			return nil, fmt.Errorf("could not create bucket %q: %w", cfg.S3Bucket, err)
+		}
+	}
+
+	return &S3Storage{client: client, bucket: cfg.S3Bucket}, nil
+}
+
+func (s *S3Storage) Put(key string, r io.Reader) (int64, error) {
+	// A size of -1 makes the client stream the body as a multipart upload.
+	info, err := s.client.PutObject(context.Background(), s.bucket, key, r, -1, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+		PartSize:    s3PartSize,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+func (s *S3Storage) Open(key string) (io.ReadSeekCloser, error) {
+	obj, err := s.client.GetObject(context.Background(), s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, mapS3Error(err)
+	}
+	// GetObject is lazy, so stat it to surface a missing key right away.
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, mapS3Error(err)
+	}
+	return obj, nil
+}
+
+func (s *S3Storage) Stat(key string) (*ObjectInfo, error) {
+	info, err := s.client.StatObject(context.Background(), s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, mapS3Error(err)
+	}
+	return &ObjectInfo{Key: info.Key, Size: info.Size, ModTime: info.LastModified}, nil
+}
+
+func (s *S3Storage) Delete(key string) error {
+	return mapS3Error(s.client.RemoveObject(context.Background(), s.bucket, key, minio.RemoveObjectOptions{}))
+}
+
+// Move copies the object server-side and removes the source. S3 has no
+// rename, but the destination only becomes visible once the copy completes.
+// Objects too large for a single copy are copied part by part.
+func (s *S3Storage) Move(src, dst string) error {
+	ctx := context.Background()
+	info, err := s.client.StatObject(ctx, s.bucket, src, minio.StatObjectOptions{})
+	if err != nil {
+		return mapS3Error(err)
+	}
+	dstOpts := minio.CopyDestOptions{Bucket: s.bucket, Object: dst}
+	srcOpts := minio.CopySrcOptions{Bucket: s.bucket, Object: src, MatchETag: info.ETag}
+	if info.Size > s3MaxCopySize {
+		_, err = s.client.ComposeObject(ctx, dstOpts, srcOpts)
+	} else {
+		_, err = s.client.CopyObject(ctx, dstOpts, srcOpts)
+	}
+	if err != nil {
+		return mapS3Error(err)
+	}
+	return mapS3Error(s.client.RemoveObject(ctx, s.bucket, src, minio.RemoveObjectOptions{}))
+}
+
+func (s *S3Storage) List(prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	for info := range s.client.ListObjects(context.Background(), s.bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	}) {
+		if info.Err != nil {
+			return nil, info.Err
+		}
+		objects = append(objects, ObjectInfo{Key: info.Key, Size: info.Size, ModTime: info.LastModified})
+	}
+	return objects, nil
+}
+
+func mapS3Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NotFound":
+		return ErrObjectNotFound
+	}
+	return err
+}
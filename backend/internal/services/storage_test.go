@@ -0,0 +1,168 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	testStorageDriver(t, NewMemoryStorage())
+}
+
+func TestLocalStorage(t *testing.T) {
+	storage, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStorageDriver(t, storage)
+
+	for _, key := range []string{"", ".", "..", "a/b", `a\b`} {
+		if _, err := storage.Put(key, strings.NewReader("data")); err == nil {
+			t.Errorf("Put(%q) succeeded, want an invalid key error", key)
+		}
+	}
+}
+
+// testStorageDriver checks the StorageService contract every driver must
+// meet. Keys are prefixed with a per-test name, so it can run against a
+// shared bucket.
+func testStorageDriver(t *testing.T, storage StorageService) {
+	prefix := "conformance-" + strings.NewReplacer("/", "-", " ", "-").Replace(t.Name()) + "-"
+	key := func(name string) string { return prefix + name }
+	t.Cleanup(func() {
+		objects, _ := storage.List(prefix)
+		for _, obj := range objects {
+			storage.Delete(obj.Key)
+		}
+	})
+
+	t.Run("PutOpenStat", func(t *testing.T) {
+		n, err := storage.Put(key("a"), strings.NewReader("hello world"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 11 {
+			t.Errorf("Put returned %d bytes, want 11", n)
+		}
+		assertObject(t, storage, key("a"), "hello world")
+
+		info, err := storage.Stat(key("a"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Key != key("a") || info.Size != 11 || info.ModTime.IsZero() {
+			t.Errorf("Stat = %+v, want key %q, size 11 and a modification time", info, key("a"))
+		}
+	})
+
+	t.Run("PutReplaces", func(t *testing.T) {
+		if _, err := storage.Put(key("replace"), strings.NewReader("old contents")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := storage.Put(key("replace"), strings.NewReader("new")); err != nil {
+			t.Fatal(err)
+		}
+		assertObject(t, storage, key("replace"), "new")
+	})
+
+	t.Run("OpenSeeks", func(t *testing.T) {
+		if _, err := storage.Put(key("seek"), strings.NewReader("0123456789")); err != nil {
+			t.Fatal(err)
+		}
+		r, err := storage.Open(key("seek"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		if _, err := r.Seek(6, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		rest, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(rest) != "6789" {
+			t.Errorf("read %q after seeking to 6, want %q", rest, "6789")
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		if _, err := storage.Open(key("missing")); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("Open of a missing key returned %v, want ErrObjectNotFound", err)
+		}
+		if _, err := storage.Stat(key("missing")); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("Stat of a missing key returned %v, want ErrObjectNotFound", err)
+		}
+		if err := storage.Move(key("missing"), key("elsewhere")); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("Move of a missing key returned %v, want ErrObjectNotFound", err)
+		}
+		// Some stores do not report deleting a missing key, so either answer is fine.
+		if err := storage.Delete(key("missing")); err != nil && !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("Delete of a missing key returned %v", err)
+		}
+	})
+
+	t.Run("Move", func(t *testing.T) {
+		if _, err := storage.Put(key("src"), strings.NewReader("moved")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := storage.Put(key("dst"), strings.NewReader("replaced")); err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.Move(key("src"), key("dst")); err != nil {
+			t.Fatal(err)
+		}
+		assertObject(t, storage, key("dst"), "moved")
+		if _, err := storage.Stat(key("src")); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("Stat of the moved source returned %v, want ErrObjectNotFound", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if _, err := storage.Put(key("delete"), strings.NewReader("gone")); err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.Delete(key("delete")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := storage.Open(key("delete")); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("Open of a deleted key returned %v, want ErrObjectNotFound", err)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		for _, name := range []string{"list-b", "list-a", "other"} {
+			if _, err := storage.Put(key(name), strings.NewReader(name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		objects, err := storage.List(key("list-"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(objects) != 2 || objects[0].Key != key("list-a") || objects[1].Key != key("list-b") {
+			t.Fatalf("List returned %+v, want %s and %s in order", objects, key("list-a"), key("list-b"))
+		}
+		if objects[0].Size != int64(len("list-a")) {
+			t.Errorf("List reported size %d for %s, want %d", objects[0].Size, objects[0].Key, len("list-a"))
+		}
+	})
+}
+
+func assertObject(t *testing.T, storage StorageService, key, want string) {
+	t.Helper()
+	r, err := storage.Open(key)
+	if err != nil {
+		t.Fatalf("Open(%q): %v", key, err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading %q: %v", key, err)
+	}
+	if string(got) != want {
+		t.Errorf("%q holds %q, want %q", key, got, want)
+	}
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"errors"
+	"strings"
+
+	"filevault-backend/internal/database"
+	"filevault-backend/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrTagNotFound    = errors.New("tag not found")
+	ErrTagExists      = errors.New("a tag with this name already exists")
+	ErrInvalidTagName = errors.New("tag names cannot be empty or contain commas")
+)
+
+// TagService manages user-defined tags. Tag names are case-insensitive and
+// stored in lower case.
+type TagService struct{}
+
+func NewTagService() *TagService {
+	return &TagService{}
+}
+
+// List returns a user's tags with the number of (non-trashed) files carrying each.
+func (s *TagService) List(userID uint) ([]models.Tag, error) {
+	var tags []models.Tag
+	if err := database.DB.Where("user_id = ?", userID).Order("name ASC").Find(&tags).Error; err != nil {
+		return nil, err
+	}
+
+	var counts []struct {
+		TagID uint
+		Count int64
+	}
+	err := database.DB.Table("file_tags").Select("file_tags.tag_id, COUNT(*) AS count").
+		Joins("JOIN files ON files.id = file_tags.file_id AND files.deleted_at IS NULL").
+		Where("files.user_id = ?", userID).
+		Group("file_tags.tag_id").Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	byTag := make(map[uint]int64, len(counts))
+	for _, count := range counts {
+		byTag[count.TagID] = count.Count
+	}
+	for i := range tags {
+		tags[i].FileCount = byTag[tags[i].ID]
+	}
+	return tags, nil
+}
+
+func (s *TagService) Get(userID, id uint) (*models.Tag, error) {
+	var tag models.Tag
+	err := database.DB.Where("id = ? AND user_id = ?", id, userID).First(&tag).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTagNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tag, nil
+}
+
+// AddToFile attaches tags to a file, creating any of its owner's tags that do
+// not exist yet.
+func (s *TagService) AddToFile(file *models.File, names []string) error {
+	var tags []models.Tag
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name, err := normalizeTagName(name)
+		if err != nil {
+			return err
+		}
+		if !seen[name] {
+			seen[name] = true
+			tags = append(tags, models.Tag{UserID: file.UserID, Name: name})
+		}
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		// Create missing tags, then load all of them to get their IDs.
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&tags).Error; err != nil {
+			return err
+		}
+		var existing []models.Tag
+		if err := tx.Where("user_id = ? AND name IN ?", file.UserID, tagNames(tags)).Find(&existing).Error; err != nil {
+			return err
+		}
+		for _, tag := range existing {
+			if err := tx.Exec("INSERT INTO file_tags (file_id, tag_id) VALUES (?, ?) ON CONFLICT DO NOTHING", file.ID, tag.ID).Error; err != nil {
+				return err
+			}
+		}
+		return loadFileTags(tx, file)
+	})
+}
+
+// RemoveFromFile detaches a tag, by name, from a file. The tag itself is kept.
+func (s *TagService) RemoveFromFile(file *models.File, name string) error {
+	name, err := normalizeTagName(name)
+	if err != nil {
+		return err
+	}
+	var tag models.Tag
+	err = database.DB.Where("user_id = ? AND name = ?", file.UserID, name).First(&tag).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrTagNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := database.DB.Exec("DELETE FROM file_tags WHERE file_id = ? AND tag_id = ?", file.ID, tag.ID).Error; err != nil {
+		return err
+	}
+	return loadFileTags(database.DB, file)
+}
+
+func (s *TagService) Rename(tag *models.Tag, name string) error {
+	name, err := normalizeTagName(name)
+	if err != nil {
+		return err
+	}
+	var count int64
+	database.DB.Model(&models.Tag{}).Where("user_id = ? AND name = ? AND id <> ?", tag.UserID, name, tag.ID).Count(&count)
+	if count > 0 {
+		return ErrTagExists
+	}
+	if err := database.DB.Model(tag).Update("name", name).Error; err != nil {
+		return err
+	}
+	tag.Name = name
+	return nil
+}
+
+// Delete removes a tag from every file and then deletes it.
+func (s *TagService) Delete(tag *models.Tag) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM file_tags WHERE tag_id = ?", tag.ID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(tag).Error
+	})
+}
+
+func loadFileTags(db *gorm.DB, file *models.File) error {
+	file.Tags = nil
+	return db.Model(file).Order("name ASC").Association("Tags").Find(&file.Tags)
+}
+
+func normalizeTagName(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || strings.Contains(name, ",") {
+		return "", ErrInvalidTagName
+	}
+	return name, nil
+}
+
+// searchTagNames normalizes the tags of a search; each entry may itself be a
+// comma-separated list.
+func searchTagNames(values []string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, value := range values {
+		for _, name := range strings.Split(value, ",") {
+			if name, err := normalizeTagName(name); err == nil && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+func tagNames(tags []models.Tag) []string {
+	names := make([]string, len(tags))
+	for i, tag := range tags {
+		names[i] = tag.Name
+	}
+	return names
+}
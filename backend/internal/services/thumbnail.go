@@ -0,0 +1,156 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Register decoders for image.Decode
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+
+	"filevault-backend/internal/models"
+
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// ThumbnailPrefix marks rendition objects in storage. Renditions are keyed by
+// the hash of the content they were made from, so they are computed once per
+// content no matter how many files share it.
+const ThumbnailPrefix = "thumb-"
+
+// ThumbnailSizes maps the sizes clients can request to the longest edge in
+// pixels.
+var ThumbnailSizes = map[string]int{
+	"small":  128,
+	"medium": 256,
+	"large":  512,
+}
+
+const DefaultThumbnailSize = "medium"
+
+// Images larger than this are not decoded, since a small compressed file can
+// expand to gigabytes of pixels.
+const maxThumbnailPixels = 50_000_000
+
+var (
+	ErrNoThumbnail          = errors.New("no thumbnail available for this file")
+	ErrInvalidThumbnailSize = errors.New("invalid thumbnail size")
+)
+
+func thumbnailKey(hash string, px int) string {
+	return fmt.Sprintf("%s%d-%s", ThumbnailPrefix, px, hash)
+}
+
+// thumbnailKeys lists every rendition object that may exist for a content.
+func thumbnailKeys(hash string) []string {
+	keys := make([]string, 0, len(ThumbnailSizes))
+	for _, px := range ThumbnailSizes {
+		keys = append(keys, thumbnailKey(hash, px))
+	}
+	return keys
+}
+
+func canThumbnail(mimeType string) bool {
+	return strings.HasPrefix(baseMimeType(mimeType), "image/")
+}
+
+// OpenThumbnail opens the rendition of content at the given size. Renditions
+// are normally made by a background job after upload; one that is missing,
+// for example for an image uploaded before thumbnails existed, is made on
+// the spot. Only content found clean, or stored while scanning was off, is
+// rendered; decoding an image that may be malicious is what the scan is for.
+func (s *FileService) OpenThumbnail(content *models.FileContent, size string) (io.ReadSeekCloser, error) {
+	px, ok := ThumbnailSizes[size]
+	if !ok {
+		return nil, ErrInvalidThumbnailSize
+	}
+	if !canThumbnail(content.MimeType) {
+		return nil, ErrNoThumbnail
+	}
+	if content.ScanStatus != models.ScanClean && content.ScanStatus != models.ScanUnscanned {
+		return nil, ErrNoThumbnail
+	}
+
+	// Renditions are encrypted with the key of the content they show.
+	dataKey, err := s.keyRing.DataKey(content.KeyID, content.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := openBlob(s.storageService, thumbnailKey(content.SHA256Hash, px), dataKey)
+	if !errors.Is(err, ErrObjectNotFound) {
+		return reader, err
+	}
+	if err := s.generateThumbnails(content); err != nil {
+		return nil, err
+	}
+	return openBlob(s.storageService, thumbnailKey(content.SHA256Hash, px), dataKey)
+}
+
+// generateThumbnails renders content in every thumbnail size. Images that
+// cannot be decoded fail with ErrNoThumbnail, marked permanent for the job
+// queue.
+func (s *FileService) generateThumbnails(content *models.FileContent) error {
+	dataKey, err := s.keyRing.DataKey(content.KeyID, content.WrappedKey)
+	if err != nil {
+		return err
+	}
+	reader, err := openBlob(s.storageService, content.SHA256Hash, dataKey)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	config, _, err := image.DecodeConfig(reader)
+	if err != nil {
+		return permanent(fmt.Errorf("%w: %v", ErrNoThumbnail, err))
+	}
+	if config.Width*config.Height > maxThumbnailPixels {
+		return permanent(fmt.Errorf("%w: image is %dx%d pixels", ErrNoThumbnail, config.Width, config.Height))
+	}
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	src, _, err := image.Decode(reader)
+	if err != nil {
+		return permanent(fmt.Errorf("%w: %v", ErrNoThumbnail, err))
+	}
+
+	for _, px := range ThumbnailSizes {
+		var buf bytes.Buffer
+		if err := encodeThumbnail(&buf, src, px); err != nil {
+			return err
+		}
+		if _, err := putBlob(s.storageService, thumbnailKey(content.SHA256Hash, px), &buf, dataKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeThumbnail scales src so its longest edge is at most px, never
+// enlarging it, and writes it as a JPEG, or as a PNG if it has transparency.
+func encodeThumbnail(w io.Writer, src image.Image, px int) error {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > px || height > px {
+		if width >= height {
+			width, height = px, max(1, height*px/width)
+		} else {
+			width, height = max(1, width*px/height), px
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+
+	if !dst.Opaque() {
+		return png.Encode(w, dst)
+	}
+	return jpeg.Encode(w, dst, &jpeg.Options{Quality: 80})
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The SHA-1 seed of the RFC 6238 appendix B test vectors, and the same seed
+// base32-encoded as a user's secret is stored.
+const (
+	rfc6238Key    = "12345678901234567890"
+	rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+)
+
+// rfc6238Vectors are the SHA-1 vectors of RFC 6238 appendix B, cut to the
+// six digits used here (the low digits of the eight-digit codes).
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestTOTPCode(t *testing.T) {
+	for _, v := range rfc6238Vectors {
+		if got := totpCode([]byte(rfc6238Key), v.unix/totpPeriod); got != v.code {
+			t.Errorf("code at %d = %s, want %s", v.unix, got, v.code)
+		}
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	for _, v := range rfc6238Vectors {
+		now := time.Unix(v.unix, 0)
+		step, ok := matchTOTP(rfc6238Secret, v.code, now)
+		if !ok || step != v.unix/totpPeriod {
+			t.Errorf("matchTOTP at %d = %d, %v; want step %d", v.unix, step, ok, v.unix/totpPeriod)
+		}
+		if _, ok := matchTOTP(strings.ToLower(rfc6238Secret), v.code, now); !ok {
+			t.Errorf("matchTOTP at %d rejected a lowercase secret", v.unix)
+		}
+	}
+
+	// The code of 1111111109 is accepted one step either side, but no further.
+	code, unix := "081804", int64(1111111109)
+	for _, drift := range []int64{-totpPeriod, totpPeriod} {
+		if _, ok := matchTOTP(rfc6238Secret, code, time.Unix(unix+drift, 0)); !ok {
+			t.Errorf("matchTOTP rejected a code %ds off", drift)
+		}
+	}
+	for _, drift := range []int64{-2 * totpPeriod, 2 * totpPeriod} {
+		if _, ok := matchTOTP(rfc6238Secret, code, time.Unix(unix+drift, 0)); ok {
+			t.Errorf("matchTOTP accepted a code %ds off", drift)
+		}
+	}
+
+	for _, bad := range []string{"", "08180", "0818040", "81804", "000000"} {
+		if _, ok := matchTOTP(rfc6238Secret, bad, time.Unix(unix, 0)); ok {
+			t.Errorf("matchTOTP accepted %q", bad)
+		}
+	}
+	if _, ok := matchTOTP("not base32!", code, time.Unix(unix, 0)); ok {
+		t.Error("matchTOTP accepted a code for an invalid secret")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri, err := url.Parse(totpURI("File Vault", "alice@example.com", rfc6238Secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/File Vault:alice@example.com" {
+		t.Errorf("URI %s does not name a TOTP account File Vault:alice@example.com", uri)
+	}
+	query := uri.Query()
+	want := map[string]string{"secret": rfc6238Secret, "issuer": "File Vault", "algorithm": "SHA1", "digits": "6", "period": "30"}
+	for key, value := range want {
+		if got := query.Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Errorf("secret %q decodes to %d bytes (%v), want 20", secret, len(key), err)
+	}
+}
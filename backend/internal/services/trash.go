@@ -0,0 +1,141 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"filevault-backend/internal/database"
+	"filevault-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var ErrNotInTrash = errors.New("file is not in the trash")
+
+// Trash moves a file to its owner's trash. Trashed files keep their versions,
+// shares and content, and keep counting against the quota, until they are
+// restored or purged; meanwhile they are hidden everywhere else.
+func (s *FileService) Trash(file *models.File) error {
+	return database.DB.Delete(file).Error
+}
+
+// ListTrash returns a user's trashed files, most recently deleted first.
+func (s *FileService) ListTrash(userID uint) ([]models.File, error) {
+	var files []models.File
+	err := database.DB.Unscoped().Preload("Content").
+		Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+		Order("deleted_at DESC").Find(&files).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range files {
+		s.fillTrashTimes(&files[i])
+	}
+	return files, nil
+}
+
+func (s *FileService) GetTrashed(userID, fileID uint) (*models.File, error) {
+	var file models.File
+	err := database.DB.Unscoped().Preload("Content").
+		Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL", fileID, userID).
+		First(&file).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotInTrash
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.fillTrashTimes(&file)
+	return &file, nil
+}
+
+// RestoreFromTrash puts a trashed file back. If its folder has been deleted
+// in the meantime, the file is restored to the root folder.
+func (s *FileService) RestoreFromTrash(file *models.File) error {
+	updates := map[string]interface{}{"deleted_at": nil}
+	if file.FolderID != nil {
+		var count int64
+		database.DB.Model(&models.Folder{}).Where("id = ?", *file.FolderID).Count(&count)
+		if count == 0 {
+			updates["folder_id"] = nil
+			file.FolderID = nil
+		}
+	}
+	if err := database.DB.Unscoped().Model(file).Updates(updates).Error; err != nil {
+		return err
+	}
+	file.DeletedAt = gorm.DeletedAt{}
+	file.TrashedAt = nil
+	file.PurgeAt = nil
+	return nil
+}
+
+// EmptyTrash permanently deletes every file in a user's trash.
+func (s *FileService) EmptyTrash(userID uint) (int, error) {
+	var fileIDs []uint
+	if err := database.DB.Unscoped().Model(&models.File{}).
+		Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+		Pluck("id", &fileIDs).Error; err != nil {
+		return 0, err
+	}
+	return s.purge(fileIDs)
+}
+
+// PurgeExpiredTrash permanently deletes files that have been in the trash for
+// longer than the retention period.
+func (s *FileService) PurgeExpiredTrash() (int, error) {
+	if s.trashRetention <= 0 {
+		return 0, nil
+	}
+	var fileIDs []uint
+	if err := database.DB.Unscoped().Model(&models.File{}).
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", time.Now().Add(-s.trashRetention)).
+		Pluck("id", &fileIDs).Error; err != nil {
+		return 0, err
+	}
+	return s.purge(fileIDs)
+}
+
+// StartTrashPurge runs PurgeExpiredTrash every interval in the background.
+func (s *FileService) StartTrashPurge(interval time.Duration) {
+	if s.trashRetention <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for ; ; <-ticker.C {
+			purged, err := s.PurgeExpiredTrash()
+			if err != nil {
+				log.Printf("Trash purge failed: %v", err)
+				continue
+			}
+			if purged > 0 {
+				log.Printf("Purged %d file(s) from the trash", purged)
+			}
+		}
+	}()
+}
+
+func (s *FileService) purge(fileIDs []uint) (int, error) {
+	for i, fileID := range fileIDs {
+		if err := s.DeleteFileAndContent(fileID); err != nil {
+			return i, err
+		}
+	}
+	return len(fileIDs), nil
+}
+
+func (s *FileService) fillTrashTimes(file *models.File) {
+	if !file.DeletedAt.Valid {
+		return
+	}
+	trashedAt := file.DeletedAt.Time
+	file.TrashedAt = &trashedAt
+	if s.trashRetention > 0 {
+		purgeAt := trashedAt.Add(s.trashRetention)
+		file.PurgeAt = &purgeAt
+	}
+}
@@ -0,0 +1,359 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"filevault-backend/internal/database"
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnrolled = errors.New("two-factor authentication has not been set up")
+	ErrTwoFactorNotEnabled  = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorRequired    = errors.New("two-factor authentication is required for admin accounts")
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+	ErrInvalidChallenge     = errors.New("invalid or expired two-factor challenge")
+	ErrIncorrectPassword    = errors.New("incorrect password")
+)
+
+const (
+	twoFactorChallengeTTL  = 5 * time.Minute
+	maxChallengeAttempts   = 5 // Wrong codes before a challenge is discarded and the password is needed again
+	recoveryCodeCount      = 10
+	settingRequireAdmin2FA = "require_admin_2fa"
+)
+
+// TOTPEnrollment is what a client needs to add an account to an
+// authenticator app: the secret, and the same secret as a provisioning URI
+// to show as a QR code.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// TwoFactorService manages TOTP two-factor authentication. Once enabled, a
+// login with the right password only yields a challenge, and tokens are
+// issued when the challenge is completed with a TOTP or recovery code.
+type TwoFactorService struct {
+	issuer         string
+	authService    *AuthService
+	sessionService *SessionService
+}
+
+func NewTwoFactorService(issuer string, authService *AuthService, sessionService *SessionService) *TwoFactorService {
+	return &TwoFactorService{
+		issuer:         issuer,
+		authService:    authService,
+		sessionService: sessionService,
+	}
+}
+
+// Enroll generates a new TOTP secret for a user after checking their
+// password. It is not used for logins until Confirm has checked that the
+// user's authenticator produces its codes; enrolling again before that
+// replaces it.
+func (s *TwoFactorService) Enroll(userID uint, password string) (*TOTPEnrollment, error) {
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if err := s.authService.CheckPassword(password, user.PasswordHash); err != nil {
+		return nil, ErrIncorrectPassword
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := database.DB.Model(&user).Update("totp_secret", secret).Error; err != nil {
+		return nil, err
+	}
+	return &TOTPEnrollment{Secret: secret, URI: totpURI(s.issuer, user.Email, secret)}, nil
+}
+
+// Confirm enables two-factor authentication once the user enters their
+// password and a code for the enrolled secret, and returns the user's
+// recovery codes. Every session but keepSession is terminated, since they
+// were started with the password alone.
+func (s *TwoFactorService) Confirm(userID uint, keepSession, password, code string) ([]string, error) {
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+	if err := s.authService.CheckPassword(password, user.PasswordHash); err != nil {
+		return nil, ErrIncorrectPassword
+	}
+	step, ok := matchTOTP(user.TOTPSecret, normalizeTwoFactorCode(code), time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	var codes []string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&user).Where("totp_enabled = ?", false).Updates(map[string]interface{}{
+			"totp_enabled":   true,
+			"totp_last_step": step,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrTwoFactorEnabled
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.sessionService.TerminateAll(user.ID, keepSession); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable turns two-factor authentication off after checking a TOTP or
+// recovery code. Admins cannot turn it off while it is required for them.
+func (s *TwoFactorService) Disable(userID uint, code string) error {
+	user, err := s.enabledUser(userID)
+	if err != nil {
+		return err
+	}
+	if user.IsAdmin {
+		required, err := s.AdminsRequired()
+		if err != nil {
+			return err
+		}
+		if required {
+			return ErrTwoFactorRequired
+		}
+	}
+	if err := s.verify(user, code); err != nil {
+		return err
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"totp_secret":    "",
+			"totp_enabled":   false,
+			"totp_last_step": 0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+}
+
+// RegenerateRecoveryCodes replaces a user's recovery codes, used or not,
+// after checking a TOTP or recovery code.
+func (s *TwoFactorService) RegenerateRecoveryCodes(userID uint, code string) ([]string, error) {
+	user, err := s.enabledUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.verify(user, code); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	return codes, err
+}
+
+// StartChallenge issues the challenge a login returns for a user with
+// two-factor authentication, in place of tokens.
+func (s *TwoFactorService) StartChallenge(user *models.User) (string, time.Time, error) {
+	challenge, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now()
+	expiresAt := now.Add(twoFactorChallengeTTL)
+
+	// Challenges are short-lived, so expired ones are swept here rather than
+	// by a background job.
+	if err := database.DB.Where("expires_at < ?", now).Delete(&models.TwoFactorChallenge{}).Error; err != nil {
+		return "", time.Time{}, err
+	}
+	if err := database.DB.Create(&models.TwoFactorChallenge{
+		TokenHash: hashTwoFactorSecret(challenge),
+		UserID:    user.ID,
+		ExpiresAt: expiresAt,
+	}).Error; err != nil {
+		return "", time.Time{}, err
+	}
+	return challenge, expiresAt, nil
+}
+
+// CompleteChallenge checks the code entered for a login challenge and
+// returns the user to issue tokens for. A challenge can be completed once,
+// and is discarded after maxChallengeAttempts wrong codes.
+func (s *TwoFactorService) CompleteChallenge(challenge, code string) (*models.User, error) {
+	var pending models.TwoFactorChallenge
+	err := database.DB.Where("token_hash = ?", hashTwoFactorSecret(challenge)).First(&pending).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidChallenge
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(pending.ExpiresAt) {
+		return nil, ErrInvalidChallenge
+	}
+
+	user, err := s.authService.GetActiveUser(pending.UserID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidChallenge
+		}
+		return nil, err
+	}
+	if !user.TOTPEnabled {
+		return nil, ErrInvalidChallenge
+	}
+
+	if err := s.verify(user, code); err != nil {
+		if errors.Is(err, ErrInvalidTwoFactorCode) {
+			s.failChallenge(&pending)
+		}
+		return nil, err
+	}
+
+	// Deleting the challenge is what completes it, so a concurrent attempt
+	// with the same challenge cannot also succeed.
+	result := database.DB.Where("token_hash = ?", pending.TokenHash).Delete(&models.TwoFactorChallenge{})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidChallenge
+	}
+	return user, nil
+}
+
+func (s *TwoFactorService) failChallenge(pending *models.TwoFactorChallenge) {
+	if pending.Attempts+1 >= maxChallengeAttempts {
+		database.DB.Where("token_hash = ?", pending.TokenHash).Delete(&models.TwoFactorChallenge{})
+		return
+	}
+	database.DB.Model(pending).Update("attempts", gorm.Expr("attempts + 1"))
+}
+
+// AdminsRequired reports whether admins must have two-factor authentication
+// to use the admin API.
+func (s *TwoFactorService) AdminsRequired() (bool, error) {
+	var setting models.Setting
+	err := database.DB.Where("key = ?", settingRequireAdmin2FA).First(&setting).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return strconv.ParseBool(setting.Value)
+}
+
+func (s *TwoFactorService) SetAdminsRequired(required bool) error {
+	return database.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at"}),
+	}).Create(&models.Setting{Key: settingRequireAdmin2FA, Value: strconv.FormatBool(required)}).Error
+}
+
+func (s *TwoFactorService) enabledUser(userID uint) (*models.User, error) {
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	if !user.TOTPEnabled {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	return &user, nil
+}
+
+// verify checks a TOTP code, or a recovery code, and uses it up: a TOTP code
+// is not accepted again, nor is any code from an earlier time step.
+func (s *TwoFactorService) verify(user *models.User, code string) error {
+	code = normalizeTwoFactorCode(code)
+
+	var result *gorm.DB
+	if len(code) == totpDigits {
+		step, ok := matchTOTP(user.TOTPSecret, code, time.Now())
+		if !ok {
+			return ErrInvalidTwoFactorCode
+		}
+		result = database.DB.Model(&models.User{}).
+			Where("id = ? AND totp_last_step < ?", user.ID, step).
+			Update("totp_last_step", step)
+	} else {
+		result = database.DB.Model(&models.RecoveryCode{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashTwoFactorSecret(code)).
+			Update("used_at", time.Now())
+	}
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// replaceRecoveryCodes generates a fresh set of recovery codes for a user,
+// invalidating the previous ones.
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, recoveryCodeCount)
+	records := make([]models.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 8)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(raw))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		records[i] = models.RecoveryCode{UserID: userID, CodeHash: hashTwoFactorSecret(code)}
+	}
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// normalizeTwoFactorCode strips the separators users type or paste along
+// with a code, such as "123 456" or "abcde-fghij".
+func normalizeTwoFactorCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(code)))
+}
+
+func hashTwoFactorSecret(secret string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(secret)))
+}
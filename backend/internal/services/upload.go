@@ -0,0 +1,217 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"filevault-backend/internal/database"
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TempObjectPrefix marks in-flight uploads in storage that have not yet been
+// promoted to their content-addressed key.
+const TempObjectPrefix = "tmp-"
+
+var (
+	ErrEmptyFile       = errors.New("file is empty")
+	ErrFileTooLarge    = errors.New("file exceeds the maximum allowed size")
+	ErrInvalidFileType = errors.New("invalid file type")
+	ErrInvalidFileName = errors.New("file names cannot be empty, '.', '..', longer than 255 bytes or contain slashes or control characters")
+)
+
+// StoreContent runs an upload through a single streaming pass: the leading
+// bytes are sniffed and validated against declaredMimeType, and the whole
+// stream is hashed while being written to a temporary object. Once the hash
+// is known the temporary object is either promoted to its content-addressed
+// key or discarded because the content already exists, and the matching
+// FileContent row is returned with its reference count incremented.
+//
+// The user's storage quota is enforced before anything is promoted, so an
+// upload that does not fit never leaves data behind. When encryption is on,
+// the stream is encrypted with a new data key on its way to storage.
+// Content declared as utils.EncryptedMimeType was encrypted by the client
+// and is not sniffed.
+func (s *FileService) StoreContent(userID uint, r io.Reader, declaredMimeType string) (*models.FileContent, error) {
+	return s.storeContent(userID, r, declaredMimeType, 0)
+}
+
+// storeContent is StoreContent for an upload that has reserved reserved bytes
+// of the user's quota, which it may use.
+func (s *FileService) storeContent(userID uint, r io.Reader, declaredMimeType string, reserved int64) (*models.FileContent, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, ErrEmptyFile
+	}
+	head = head[:n]
+
+	if err := utils.ValidateMimeType(declaredMimeType, head); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidFileType, err.Error())
+	}
+
+	// A negative limit means the stream is unbounded.
+	limit, limitErr := int64(-1), ErrFileTooLarge
+	if max := s.maxUploadSize(declaredMimeType); max > 0 {
+		limit = max
+	}
+	if s.ChargesUpfront() {
+		// Every byte counts against the quota, so stop reading as soon as it is used up.
+		remaining, err := s.remainingQuota(database.DB, userID, reserved)
+		if err != nil {
+			return nil, err
+		}
+		if remaining >= 0 && (limit < 0 || remaining < limit) {
+			limit, limitErr = remaining, ErrQuotaExceeded
+		}
+	}
+
+	hasher := sha256.New()
+	body := io.TeeReader(io.MultiReader(bytes.NewReader(head), r), hasher)
+	if limit >= 0 {
+		// Read one byte past the limit so oversized uploads can be detected.
+		body = io.LimitReader(body, limit+1)
+	}
+
+	dataKey, keyID, wrappedKey, err := s.keyRing.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+	tempKey := TempObjectPrefix + uuid.NewString()
+	size, err := putBlob(s.storageService, tempKey, body, dataKey)
+	if err != nil {
+		s.storageService.Delete(tempKey)
+		return nil, err
+	}
+	if limit >= 0 && size > limit {
+		s.storageService.Delete(tempKey)
+		return nil, limitErr
+	}
+
+	hash := fmt.Sprintf("%x", hasher.Sum(nil))
+	if err := s.checkQuota(database.DB, userID, hash, size, reserved); err != nil {
+		s.storageService.Delete(tempKey)
+		return nil, err
+	}
+	return s.promoteContent(tempKey, hash, size, declaredMimeType, keyID, wrappedKey)
+}
+
+// promoteContent registers a fully written temporary object under its hash
+// and returns the content row with a reference taken on it. Every upload is
+// encrypted with its own data key, so two uploads of the same data are not
+// byte-identical: only the upload that creates the row moves its object into
+// place, and does so while holding the hash lock, and every other upload
+// discards its own object and uses the stored one.
+func (s *FileService) promoteContent(tempKey, hash string, size int64, mimeType, keyID string, wrappedKey []byte) (*models.FileContent, error) {
+	for {
+		existing, err := s.referenceContent(hash)
+		if err != nil || existing != nil {
+			// Duplicate content: keep the stored copy and drop the new one.
+			s.storageService.Delete(tempKey)
+			return existing, err
+		}
+
+		content := &models.FileContent{
+			SHA256Hash: hash,
+			FileSize:   size,
+			MimeType:   mimeType,
+			ScanStatus: models.ScanUnscanned,
+			KeyID:      keyID,
+			WrappedKey: wrappedKey,
+		}
+		// Scanning ciphertext cannot find anything, so client-encrypted content
+		// stays unscanned.
+		if s.scanner != nil && mimeType != utils.EncryptedMimeType {
+			content.ScanStatus = models.ScanPending
+		}
+
+		created := false
+		err = database.DB.Transaction(func(tx *gorm.DB) error {
+			if err := lockContentHash(tx, hash); err != nil {
+				return err
+			}
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(content)
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			if err := s.enqueueContentJobs(tx, content); err != nil {
+				return err
+			}
+			// An earlier copy of the content may have left objects behind,
+			// encrypted with its own data key.
+			if err := s.deleteBlob(hash); err != nil {
+				return err
+			}
+			if err := s.storageService.Move(tempKey, hash); err != nil {
+				return err
+			}
+			created = true
+			return nil
+		})
+		if err != nil {
+			s.storageService.Delete(tempKey)
+			return nil, err
+		}
+		if created {
+			return content, nil
+		}
+		// A concurrent upload of the same content created the row first.
+	}
+}
+
+// referenceContent takes a reference to the content stored under hash, and
+// returns nil if there is none, including when the row is deleted between
+// reading it and taking the reference.
+func (s *FileService) referenceContent(hash string) (*models.FileContent, error) {
+	var content models.FileContent
+	err := database.DB.Where("sha256_hash = ?", hash).First(&content).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := database.DB.Model(&content).UpdateColumn("reference_count", gorm.Expr("reference_count + 1"))
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	content.ReferenceCount++
+	return &content, nil
+}
+
+// maxUploadSize is the largest upload of the given type that is accepted, or
+// 0 if there is no limit. While content that cannot be scanned cannot be
+// downloaded either, content that would be scanned is also capped at what
+// the scanner accepts.
+func (s *FileService) maxUploadSize(mimeType string) int64 {
+	limit := s.maxFileSize
+	if s.scanner != nil && s.scanFailurePolicy == ScanFailureBlock && mimeType != utils.EncryptedMimeType {
+		if max := s.scanner.MaxSize(); max > 0 && (limit <= 0 || max < limit) {
+			limit = max
+		}
+	}
+	return limit
+}
+
+// OpenContent opens the stored data of content for reading, decrypting it if
+// it was stored encrypted.
+func (s *FileService) OpenContent(content *models.FileContent) (io.ReadSeekCloser, error) {
+	dataKey, err := s.keyRing.DataKey(content.KeyID, content.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	return openBlob(s.storageService, content.SHA256Hash, dataKey)
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"errors"
+
+	"filevault-backend/internal/database"
+	"filevault-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+
+// UserService holds the account management operations used by admins.
+type UserService struct {
+	fileService    *FileService
+	sessionService *SessionService
+}
+
+func NewUserService(fileService *FileService, sessionService *SessionService) *UserService {
+	return &UserService{
+		fileService:    fileService,
+		sessionService: sessionService,
+	}
+}
+
+func (s *UserService) GetByID(id uint) (*models.User, error) {
+	var user models.User
+	if err := database.DB.First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UpdateQuota sets a user's quota in bytes, -1 meaning unlimited. A nil quota
+// makes the configured default apply again.
+func (s *UserService) UpdateQuota(id uint, quota *int64) (*models.User, error) {
+	return s.update(id, "storage_quota", quota)
+}
+
+func (s *UserService) SetAdmin(id uint, isAdmin bool) (*models.User, error) {
+	return s.update(id, "is_admin", isAdmin)
+}
+
+// SetSuspended blocks or restores an account. Suspended users can neither
+// log in nor use tokens issued before the suspension, and their sessions are
+// terminated so reactivating the account does not bring them back.
+func (s *UserService) SetSuspended(id uint, suspended bool) (*models.User, error) {
+	user, err := s.update(id, "is_suspended", suspended)
+	if err != nil || !suspended {
+		return user, err
+	}
+	if _, err := s.sessionService.TerminateAll(user.ID, ""); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Delete removes a user together with all of their files and folders,
+// including the trash, releasing any content no other file references. Audit
+// entries about the user are kept, but no longer point at them.
+//
+// The account is suspended first and then everything is deleted in one
+// transaction, so a delete that fails leaves a locked account with its data
+// intact, and calling Delete again finishes the job.
+func (s *UserService) Delete(id uint) (int, error) {
+	user, err := s.SetSuspended(id, true)
+	if err != nil {
+		return 0, err
+	}
+
+	var fileIDs []uint
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// Rows that refer to the user go first. Shares are soft-deleted
+		// elsewhere, so they have to be deleted unscoped.
+		if err := tx.Model(&models.AuditLog{}).Where("user_id = ?", user.ID).Update("user_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id = ? OR share_with = ?", user.ID, user.ID).Delete(&models.FileShare{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.RefreshToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.Session{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.TwoFactorChallenge{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.FileKey{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.UploadSession{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Model(&models.File{}).Where("user_id = ?", user.ID).Pluck("id", &fileIDs).Error; err != nil {
+			return err
+		}
+		for _, fileID := range fileIDs {
+			if err := s.fileService.deleteFileAndContent(tx, fileID); err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.Folder{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.Tag{}).Error; err != nil {
+			return err
+		}
+		// A hard delete, so the username and email can be registered again.
+		return tx.Unscoped().Delete(user).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(fileIDs), nil
+}
+
+func (s *UserService) update(id uint, column string, value interface{}) (*models.User, error) {
+	user, err := s.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := database.DB.Model(user).Update(column, value).Error; err != nil {
+		return nil, err
+	}
+	return user, nil
+}
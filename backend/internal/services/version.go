@@ -0,0 +1,181 @@
+package services
+
+import (
+	"errors"
+
+	"filevault-backend/internal/database"
+	"filevault-backend/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrVersionNotFound = errors.New("version not found")
+
+// SaveUpload records uploaded content under a filename. When the user already
+// has a file with that name in the folder, the content becomes its next
+// version; otherwise a new file is created. created reports which happened.
+//
+// encryption is set for content the client encrypted itself, and creating an
+// encrypted file stores the uploader's wrapped file key with it. Encrypted
+// and plaintext content never mix in one file's history, and every version
+// of an encrypted file is encrypted with the same file key, so collaborators
+// keep access. The content is checked against the user's quota once more as
+// it is saved, and content that cannot be saved is released again.
+func (s *FileService) SaveUpload(userID uint, folderID *uint, filename string, content *models.FileContent, encryption *models.ClientEncryption) (*models.File, bool, error) {
+	return s.saveUpload(userID, folderID, filename, content, encryption, 0)
+}
+
+// saveUpload is SaveUpload for an upload that has reserved reserved bytes of
+// the user's quota.
+func (s *FileService) saveUpload(userID uint, folderID *uint, filename string, content *models.FileContent, encryption *models.ClientEncryption, reserved int64) (file *models.File, created bool, err error) {
+	defer func() {
+		if err != nil {
+			s.releaseContent(content)
+		}
+	}()
+	if filename, err = validFileName(filename); err != nil {
+		return nil, false, err
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := s.chargeQuota(tx, userID, content, reserved); err != nil {
+			return err
+		}
+
+		var existing models.File
+		err := scopeFolder(tx.Where("user_id = ? AND original_filename = ?", userID, filename), folderID).
+			Order("id DESC").First(&existing).Error
+		if err == nil {
+			if existing.Encrypted != (encryption != nil) {
+				return ErrEncryptionMismatch
+			}
+			file = &existing
+			_, err = addVersion(tx, file, content, userID, nil, false)
+			return err
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		file = &models.File{
+			UserID:           userID,
+			FileContentID:    content.ID,
+			FolderID:         folderID,
+			OriginalFilename: filename,
+		}
+		created = true
+		if encryption == nil {
+			return createFile(tx, file)
+		}
+		if encryption.WrappedKey == "" {
+			return ErrWrappedKeyRequired
+		}
+		file.Encrypted = true
+		file.EncryptionMetadata = encryption.Metadata
+		return createEncrypted(tx, file, encryption.WrappedKey)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	file.Content = *content
+	return file, created, nil
+}
+
+// AddVersion makes content (as returned by StoreContent) the current version
+// of a file, once it has been checked against the quota of the file's owner.
+// Earlier versions keep their content, so they can be restored. The new
+// version takes over the reference StoreContent took on the content, which
+// is released if the version cannot be added.
+func (s *FileService) AddVersion(file *models.File, content *models.FileContent, uploadedBy uint, restoredFrom *int) (*models.FileVersion, error) {
+	var version *models.FileVersion
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := s.chargeQuota(tx, file.UserID, content, 0); err != nil {
+			return err
+		}
+		var err error
+		version, err = addVersion(tx, file, content, uploadedBy, restoredFrom, false)
+		return err
+	})
+	if err != nil {
+		s.releaseContent(content)
+		return nil, err
+	}
+	return version, nil
+}
+
+// addVersion adds a version of a file using content. Unless takeReference is
+// set, the caller already holds the reference the version needs.
+func addVersion(tx *gorm.DB, file *models.File, content *models.FileContent, uploadedBy uint, restoredFrom *int, takeReference bool) (*models.FileVersion, error) {
+	// Lock the file so concurrent uploads get distinct version numbers.
+	var current models.File
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, file.ID).Error; err != nil {
+		return nil, err
+	}
+
+	version := models.FileVersion{
+		FileID:        file.ID,
+		Version:       current.CurrentVersion + 1,
+		FileContentID: content.ID,
+		UploadedBy:    uploadedBy,
+		RestoredFrom:  restoredFrom,
+	}
+	if err := tx.Create(&version).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&current).Updates(map[string]interface{}{
+		"file_content_id": content.ID,
+		"current_version": version.Version,
+	}).Error; err != nil {
+		return nil, err
+	}
+	if takeReference {
+		if err := tx.Model(&models.FileContent{}).Where("id = ?", content.ID).
+			UpdateColumn("reference_count", gorm.Expr("reference_count + 1")).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	file.FileContentID = content.ID
+	file.CurrentVersion = version.Version
+	file.Content = *content
+	version.Content = *content
+	return &version, nil
+}
+
+// ListVersions returns a file's history, newest first.
+func (s *FileService) ListVersions(fileID uint) ([]models.FileVersion, error) {
+	var versions []models.FileVersion
+	err := database.DB.Preload("Content").Where("file_id = ?", fileID).Order("version DESC").Find(&versions).Error
+	return versions, err
+}
+
+func (s *FileService) GetVersion(fileID uint, version int) (*models.FileVersion, error) {
+	var v models.FileVersion
+	err := database.DB.Preload("Content").Where("file_id = ? AND version = ?", fileID, version).First(&v).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrVersionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// RestoreVersion rolls a file back to an earlier version. The rollback is
+// itself recorded as a new version, so no history is lost.
+func (s *FileService) RestoreVersion(file *models.File, version int, restoredBy uint) (*models.FileVersion, error) {
+	old, err := s.GetVersion(file.ID, version)
+	if err != nil {
+		return nil, err
+	}
+	var restored *models.FileVersion
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		restored, err = addVersion(tx, file, &old.Content, restoredBy, &old.Version, true)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return restored, nil
+}
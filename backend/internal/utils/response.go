@@ -3,8 +3,9 @@ package utils
 import (
 	"net/http"
 
-	"github.com/gin-gonic/gin"
 	"filevault-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
 )
 
 func SuccessResponse(c *gin.Context, message string, data interface{}) {
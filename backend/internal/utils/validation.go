@@ -3,31 +3,23 @@ package utils
 
 import (
 	"fmt"
-	"mime/multipart"
 	"net/http"
 	"strings"
 )
 
-// ValidateFileType checks the actual content type of a file against its declared MIME type.
-func ValidateFileType(fileHeader *multipart.FileHeader) error {
-	declaredMimeType := fileHeader.Header.Get("Content-Type")
+// EncryptedMimeType is the type of data encrypted by the client before upload.
+// Such data is opaque to the server, so it is never sniffed or inspected.
+const EncryptedMimeType = "application/x-filevault-encrypted"
 
-	// Open the file to read its content
-	file, err := fileHeader.Open()
-	if err != nil {
-		return fmt.Errorf("could not open file for validation: %w", err)
-	}
-	defer file.Close()
-
-	// Read the first 512 bytes to detect the content type
-	buffer := make([]byte, 512)
-	_, err = file.Read(buffer)
-	if err != nil {
-		return fmt.Errorf("could not read file for validation: %w", err)
+// ValidateMimeType checks sniffed leading bytes of a file against its declared MIME type.
+func ValidateMimeType(declaredMimeType string, head []byte) error {
+	// Ciphertext looks like random bytes whatever the plaintext was.
+	if declaredMimeType == EncryptedMimeType {
+		return nil
 	}
 
 	// Detect the actual MIME type from the content
-	detectedMimeType := http.DetectContentType(buffer)
+	detectedMimeType := http.DetectContentType(head)
 
 	// Allow for some flexibility (e.g., 'text/plain' vs 'text/csv')
 	// We compare the base type (e.g., 'text', 'image', 'application')
@@ -39,7 +31,7 @@ func ValidateFileType(fileHeader *multipart.FileHeader) error {
 		if detectedMimeType == "application/zip" {
 			switch declaredMimeType {
 			case "application/vnd.openxmlformats-officedocument.wordprocessingml.document", // .docx
-				"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",      // .xlsx
+				"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",         // .xlsx
 				"application/vnd.openxmlformats-officedocument.presentationml.presentation": // .pptx
 				return nil // Allow these as they are zip-based formats
 			}
@@ -48,4 +40,4 @@ func ValidateFileType(fileHeader *multipart.FileHeader) error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
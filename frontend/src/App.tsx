@@ -9,7 +9,8 @@ import { useDropzone } from 'react-dropzone';
 // --- TYPE DEFINITIONS ---
 interface FileContent { fileSize: number; mime_type: string; }
 interface FileOwner { username: string; }
-interface FileItem { id: number; original_filename: string; content: FileContent; user: FileOwner; created_at: string; download_count: number; is_public: boolean; }
+interface FileItem { id: number; original_filename: string; content: FileContent; user: FileOwner; created_at: string; download_count: number; }
+interface ShareLink { id: number; token: string; expires_at: string | null; created_at: string; has_password: boolean; max_downloads: number | null; download_count: number; }
 interface User { id: number; username: string; email: string; is_admin: boolean; }
 interface StorageStats { total_storage_used: number; original_storage_usage: number; storage_savings_bytes: number; storage_savings_percentage: number; user_quota: number; }
 interface SystemStats { total_users: number; total_files: number; total_storage_used: number; original_total_size: number; deduplication_saved: number; savings_percentage: number; }
@@ -17,8 +18,32 @@ interface SystemStats { total_users: number; total_files: number; total_storage_
 // --- API HELPER ---
 const API_BASE_URL = process.env.REACT_APP_API_URL || 'http://localhost:8080/api/v1';
 const getAuthToken = () => localStorage.getItem('token');
+const storeTokens = (data: { token: string; refresh_token: string; expires_at: string }) => {
+    localStorage.setItem('token', data.token);
+    localStorage.setItem('refresh_token', data.refresh_token);
+    localStorage.setItem('token_expires_at', data.expires_at);
+};
+const clearTokens = () => { ['token', 'refresh_token', 'token_expires_at'].forEach(key => localStorage.removeItem(key)); };
+
+// Each refresh token can only be used once, so concurrent requests share a single refresh.
+let pendingRefresh: Promise<boolean> | null = null;
+const refreshTokens = () => {
+    if (!pendingRefresh) {
+        pendingRefresh = (async () => {
+            const refreshToken = localStorage.getItem('refresh_token');
+            if (!refreshToken) return false;
+            try {
+                const response = await fetch(`${API_BASE_URL}/auth/refresh`, { method: 'POST', headers: { 'Content-Type': 'application/json' }, body: JSON.stringify({ refresh_token: refreshToken }) });
+                if (!response.ok) return false;
+                storeTokens((await response.json()).data);
+                return true;
+            } catch { return false; }
+        })().finally(() => { pendingRefresh = null; });
+    }
+    return pendingRefresh;
+};
 
-const apiRequest = async (endpoint: string, options: RequestInit = {}) => {
+const apiRequest = async (endpoint: string, options: RequestInit = {}, retry = true): Promise<any> => {
     const token = getAuthToken();
     const headers: Record<string, string> = { ...(options.headers as Record<string, string>) };
     if (!(options.body instanceof FormData)) { headers['Content-Type'] = 'application/json'; }
@@ -26,7 +51,10 @@ const apiRequest = async (endpoint: string, options: RequestInit = {}) => {
     try {
         const response = await fetch(`${API_BASE_URL}${endpoint}`, { ...options, headers });
         if (!response.ok) {
-            if (response.status === 401) { localStorage.removeItem('token'); window.location.reload(); }
+            if (response.status === 401) {
+                if (retry && !endpoint.startsWith('/auth/') && await refreshTokens()) { return apiRequest(endpoint, options, false); }
+                clearTokens(); window.location.reload();
+            }
             const errorData = await response.json().catch(() => ({ error: 'An unknown error occurred' }));
             throw new Error(errorData.error || `Request failed with status ${response.status}`);
         }
@@ -54,16 +82,27 @@ const AuthForm: React.FC<{ onAuthSuccess: (user: User) => void }> = ({ onAuthSuc
     const [isLoading, setIsLoading] = useState(false);
     const [error, setError] = useState<string | null>(null);
     const [formData, setFormData] = useState({ username: '', email: '', password: '' });
+    const [challenge, setChallenge] = useState<string | null>(null);
+    const [code, setCode] = useState('');
 
     const handleSubmit = async (e: React.FormEvent) => {
         e.preventDefault();
         setIsLoading(true);
         setError(null);
         try {
-            const endpoint = isLogin ? '/auth/login' : '/auth/register';
-            const payload = isLogin ? { email: formData.email, password: formData.password } : formData;
-            const data = await apiRequest(endpoint, { method: 'POST', body: JSON.stringify(payload) });
-            localStorage.setItem('token', data.data.token);
+            let data;
+            if (challenge) {
+                data = await apiRequest('/auth/2fa', { method: 'POST', body: JSON.stringify({ challenge, code }) });
+            } else {
+                const endpoint = isLogin ? '/auth/login' : '/auth/register';
+                const payload = isLogin ? { email: formData.email, password: formData.password } : formData;
+                data = await apiRequest(endpoint, { method: 'POST', body: JSON.stringify(payload) });
+                if (data.data.two_factor_required) {
+                    setChallenge(data.data.challenge);
+                    return;
+                }
+            }
+            storeTokens(data.data);
             onAuthSuccess(data.data.user);
         } catch (err: any) {
             setError(err.message);
@@ -76,12 +115,20 @@ const AuthForm: React.FC<{ onAuthSuccess: (user: User) => void }> = ({ onAuthSuc
             <div className="bg-white p-8 rounded-xl shadow-md w-full max-w-md">
                 <div className="flex justify-center items-center mb-6 space-x-3"><ShieldCheck className="w-10 h-10 text-balkan-blue" /><h1 className="text-3xl font-bold text-gray-800">Secure File Vault</h1></div>
                 <h2 className="text-2xl font-bold text-center mb-6 text-gray-700">{isLogin ? 'Sign In' : 'Create Account'}</h2>
+                {challenge ? (
+                <form onSubmit={handleSubmit} className="space-y-4">
+                    <input type="text" inputMode="numeric" autoComplete="one-time-code" placeholder="Authenticator or recovery code" value={code} onChange={(e) => setCode(e.target.value)} className="w-full px-4 py-2 border border-gray-300 rounded-md focus:outline-none focus:ring-2 focus:ring-balkan-blue" required autoFocus />
+                    <button type="submit" disabled={isLoading} className="w-full bg-balkan-blue text-white py-2 px-4 rounded-md hover:bg-balkan-purple disabled:opacity-50 flex items-center justify-center transition-colors">{isLoading ? <Loader2 className="w-5 h-5 animate-spin mr-2" /> : <ShieldCheck className="w-5 h-5 mr-2" />}{isLoading ? 'Please wait...' : 'Verify'}</button>
+                    <button type="button" onClick={() => { setChallenge(null); setCode(''); }} className="w-full text-balkan-blue hover:text-balkan-purple font-medium">Back to sign in</button>
+                </form>
+                ) : (
                 <form onSubmit={handleSubmit} className="space-y-4">
                     {!isLogin && (<input type="text" placeholder="Username" value={formData.username} onChange={(e) => setFormData(p => ({ ...p, username: e.target.value }))} className="w-full px-4 py-2 border border-gray-300 rounded-md focus:outline-none focus:ring-2 focus:ring-balkan-blue" required />)}
                     <input type="email" placeholder="Email" value={formData.email} onChange={(e) => setFormData(p => ({ ...p, email: e.target.value }))} className="w-full px-4 py-2 border border-gray-300 rounded-md focus:outline-none focus:ring-2 focus:ring-balkan-blue" required />
                     <input type="password" placeholder="Password" value={formData.password} onChange={(e) => setFormData(p => ({ ...p, password: e.target.value }))} className="w-full px-4 py-2 border border-gray-300 rounded-md focus:outline-none focus:ring-2 focus:ring-balkan-blue" required minLength={6} />
                     <button type="submit" disabled={isLoading} className="w-full bg-balkan-blue text-white py-2 px-4 rounded-md hover:bg-balkan-purple disabled:opacity-50 flex items-center justify-center transition-colors">{isLoading ? <Loader2 className="w-5 h-5 animate-spin mr-2" /> : isLogin ? <LogIn className="w-5 h-5 mr-2" /> : <UserPlus className="w-5 h-5 mr-2" />}{isLoading ? 'Please wait...' : (isLogin ? 'Sign In' : 'Create Account')}</button>
                 </form>
+                )}
                 {error && (<div className="mt-4 text-red-700 bg-red-100 p-3 rounded-lg flex items-center"><AlertTriangle className="w-5 h-5 mr-2" /> {error}</div>)}
                 <p className="mt-6 text-center text-gray-600">{isLogin ? "Don't have an account?" : "Already have an account?"}<button type="button" onClick={() => setIsLogin(!isLogin)} className="ml-2 text-balkan-blue hover:text-balkan-purple font-medium">{isLogin ? 'Create one' : 'Sign in'}</button></p>
             </div>
@@ -181,19 +228,44 @@ const SearchAndFilter: React.FC<{ onFilterChange: (filters: any) => void }> = ({
 };
 
 const ShareModal: React.FC<{ file: FileItem | null, onClose: () => void, onShareUpdate: () => void }> = ({ file, onClose, onShareUpdate }) => {
+    const [shares, setShares] = useState<ShareLink[]>([]);
+    const [expiresInHours, setExpiresInHours] = useState('');
+    const [password, setPassword] = useState('');
+    const [maxDownloads, setMaxDownloads] = useState('');
+    const [error, setError] = useState<string | null>(null);
+    const fetchShares = useCallback(async () => {
+        if (!file) return;
+        try { const res = await apiRequest(`/files/${file.id}/shares`); setShares(res.data.shares || []); }
+        catch (err: any) { setError(err.message); }
+    }, [file]);
+    useEffect(() => { fetchShares(); }, [fetchShares]);
     if (!file) return null;
-    const publicLink = `${API_BASE_URL}/public/files/${file.id}/download`;
-    const handleTogglePublic = async () => {
-        try { await apiRequest(`/files/${file.id}/share`, { method: 'PUT' }); onShareUpdate(); }
-        catch (error) { console.error("Failed to update share status", error); }
+    const linkFor = (share: ShareLink) => `${API_BASE_URL}/public/shares/${share.token}/download`;
+    const handleCreate = async () => {
+        setError(null);
+        const hours = parseInt(expiresInHours, 10);
+        const limit = parseInt(maxDownloads, 10);
+        const options: Record<string, unknown> = {};
+        if (hours > 0) options.expires_in_hours = hours;
+        if (limit > 0) options.max_downloads = limit;
+        if (password) options.password = password;
+        try { await apiRequest(`/files/${file.id}/shares`, { method: 'POST', body: JSON.stringify(options) }); setExpiresInHours(''); setPassword(''); setMaxDownloads(''); fetchShares(); onShareUpdate(); }
+        catch (err: any) { setError(err.message); }
+    };
+    const handleRevoke = async (shareId: number) => {
+        try { await apiRequest(`/files/${file.id}/shares/${shareId}`, { method: 'DELETE' }); fetchShares(); onShareUpdate(); }
+        catch (err: any) { setError(err.message); }
     };
     return (
         <div className="fixed inset-0 bg-black bg-opacity-50 flex items-center justify-center z-50 p-4">
             <div className="bg-white rounded-lg shadow-xl p-6 w-full max-w-lg">
                 <div className="flex justify-between items-center mb-4"><h3 className="text-xl font-bold truncate">Share "{file.original_filename}"</h3><button onClick={onClose} className="text-gray-500 hover:text-gray-800"><X size={24} /></button></div>
                 <div className="space-y-4">
-                    <div className="flex items-center justify-between p-3 bg-gray-50 rounded-md"><label htmlFor="public-toggle" className="font-medium">Enable Public Link</label><button onClick={handleTogglePublic} className={`relative inline-flex items-center h-6 rounded-full w-11 transition-colors ${file.is_public ? 'bg-balkan-blue' : 'bg-gray-200'}`}><span className={`inline-block w-4 h-4 transform bg-white rounded-full transition-transform ${file.is_public ? 'translate-x-6' : 'translate-x-1'}`} /></button></div>
-                    {file.is_public && (<div><label className="text-sm font-medium text-gray-700">Anyone with this link can download the file:</label><div className="flex items-center space-x-2 mt-1"><input type="text" readOnly value={publicLink} className="w-full p-2 border rounded-md bg-gray-100" /><button onClick={() => navigator.clipboard.writeText(publicLink)} className="p-2 bg-balkan-blue text-white rounded-md hover:bg-balkan-purple" title="Copy link"><LinkIcon size={20} /></button></div></div>)}
+                    {error && <div className="text-balkan-pink bg-balkan-pink-100 p-3 rounded-md text-sm">{error}</div>}
+                    <div className="grid grid-cols-2 gap-2 p-3 bg-gray-50 rounded-md"><input type="number" min="1" placeholder="Expires in hours (optional)" value={expiresInHours} onChange={e => setExpiresInHours(e.target.value)} className="p-2 border rounded-md" /><input type="number" min="1" placeholder="Max downloads (optional)" value={maxDownloads} onChange={e => setMaxDownloads(e.target.value)} className="p-2 border rounded-md" /><input type="password" placeholder="Password (optional)" value={password} onChange={e => setPassword(e.target.value)} className="p-2 border rounded-md" /><button onClick={handleCreate} className="px-4 py-2 bg-balkan-blue text-white rounded-md hover:bg-balkan-purple">Create Link</button></div>
+                    {shares.length === 0 ? <p className="text-sm text-gray-500">No share links yet.</p> : shares.map(share => (
+                        <div key={share.id}><label className="text-sm font-medium text-gray-700">Anyone with this link{share.has_password ? ' and its password' : ''} can download the file{share.expires_at ? ` until ${new Date(share.expires_at).toLocaleString()}` : ''}{share.max_downloads ? ` (${share.download_count}/${share.max_downloads} downloads used)` : ''}:</label><div className="flex items-center space-x-2 mt-1"><input type="text" readOnly value={linkFor(share)} className="w-full p-2 border rounded-md bg-gray-100" /><button onClick={() => navigator.clipboard.writeText(linkFor(share))} className="p-2 bg-balkan-blue text-white rounded-md hover:bg-balkan-purple" title="Copy link"><LinkIcon size={20} /></button><button onClick={() => handleRevoke(share.id)} className="p-2 text-gray-500 hover:text-balkan-pink rounded-md hover:bg-gray-100" title="Revoke link"><Trash2 size={20} /></button></div></div>
+                    ))}
                 </div>
             </div>
         </div>
@@ -206,11 +278,11 @@ const FileList: React.FC<{ files: FileItem[], onDelete: (fileId: number) => void
         <div className="space-y-3">
             {files.map((file) => (
                 <div key={file.id} className="bg-white p-4 rounded-lg border hover:shadow-md transition-shadow flex items-center justify-between">
-                    <div className="flex items-center flex-1 min-w-0"><FileIcon className="w-6 h-6 text-balkan-blue mr-4 flex-shrink-0" /><div className="min-w-0 flex-1"><p className="font-medium text-gray-900 truncate">{file.original_filename}</p><div className="text-sm text-gray-500 flex items-center flex-wrap gap-x-4 gap-y-1 mt-1"><span>{formatBytes(file.content.file_size)}</span><span>by {file.user.username}</span><span>{new Date(file.created_at).toLocaleDateString()}</span><span className="flex items-center"><Download className="w-4 h-4 mr-1" /> {file.download_count}</span>{file.is_public && <span className="px-2 py-1 bg-balkan-green-100 text-balkan-green-800 rounded-full text-xs font-medium">Public</span>}</div></div></div>
+                    <div className="flex items-center flex-1 min-w-0"><FileIcon className="w-6 h-6 text-balkan-blue mr-4 flex-shrink-0" /><div className="min-w-0 flex-1"><p className="font-medium text-gray-900 truncate">{file.original_filename}</p><div className="text-sm text-gray-500 flex items-center flex-wrap gap-x-4 gap-y-1 mt-1"><span>{formatBytes(file.content.file_size)}</span><span>by {file.user.username}</span><span>{new Date(file.created_at).toLocaleDateString()}</span><span className="flex items-center"><Download className="w-4 h-4 mr-1" /> {file.download_count}</span></div></div></div>
                     <div className="flex items-center space-x-2 ml-4">
                         <button onClick={() => onShare(file)} className="p-2 text-gray-500 hover:text-balkan-blue rounded-full hover:bg-gray-100" title="Share file"><Share2 size={18} /></button>
                         <button onClick={() => onDelete(file.id)} className="p-2 text-gray-500 hover:text-balkan-pink rounded-full hover:bg-gray-100" title="Delete file"><Trash2 size={18} /></button>
-                        <a href={`${API_BASE_URL}/files/${file.id}/download?token=${getAuthToken()}`} target="_blank" rel="noopener noreferrer" className="p-2 text-gray-500 hover:text-balkan-green rounded-full hover:bg-gray-100" title="Download file"><Download size={18} /></a>
+                        <a href={`${API_BASE_URL}/files/${file.id}/download?token=${getAuthToken()}`} onClick={e => { e.currentTarget.href = `${API_BASE_URL}/files/${file.id}/download?token=${getAuthToken()}`; }} target="_blank" rel="noopener noreferrer" className="p-2 text-gray-500 hover:text-balkan-green rounded-full hover:bg-gray-100" title="Download file"><Download size={18} /></a>
                     </div>
                 </div>
             ))}
@@ -282,7 +354,7 @@ function App() {
         const token = getAuthToken();
         if (!token) { setIsLoading(false); return; }
         try { const profileData = await apiRequest('/profile'); setUser(profileData.data); }
-        catch (err) { localStorage.removeItem('token'); }
+        catch (err) { clearTokens(); }
         finally { setIsLoading(false); }
     }, []);
 
@@ -299,15 +371,28 @@ function App() {
 
     useEffect(() => { checkAuthStatus(); }, [checkAuthStatus]);
     useEffect(() => { if (user) { fetchData(); } }, [user, fetchData]);
+    // Renew the access token shortly before it expires, so download links built from it keep working.
+    useEffect(() => {
+        if (!user) return;
+        const timer = setInterval(() => {
+            const expiresAt = Date.parse(localStorage.getItem('token_expires_at') || '');
+            if (expiresAt && expiresAt - Date.now() < 2 * 60 * 1000) { refreshTokens(); }
+        }, 60 * 1000);
+        return () => clearInterval(timer);
+    }, [user]);
 
-    const handleLogout = () => { localStorage.removeItem('token'); setUser(null); };
+    const handleLogout = async () => {
+        const refreshToken = localStorage.getItem('refresh_token');
+        if (refreshToken) { await apiRequest('/auth/logout', { method: 'POST', body: JSON.stringify({ refresh_token: refreshToken }) }).catch(() => {}); }
+        clearTokens(); setUser(null);
+    };
     const handleDeleteFile = async (fileId: number) => {
         if (window.confirm('Are you sure you want to delete this file?')) {
             try { await apiRequest(`/files/${fileId}`, { method: 'DELETE' }); showNotification('File deleted successfully.', 'success'); fetchData(); }
             catch (err: any) { showNotification(`Error: ${err.message}`, 'error'); }
         }
     };
-    const handleShareUpdate = () => { setSharingFile(null); fetchData(); };
+    const handleShareUpdate = () => { fetchData(); };
 
     if (isLoading) { return <div className="min-h-screen bg-gray-50 flex items-center justify-center"><Loader2 className="w-8 h-8 animate-spin text-balkan-blue" /></div>; }
     if (!user) { return <AuthForm onAuthSuccess={(userData) => setUser(userData)} />; }